@@ -0,0 +1,22 @@
+# Custom application metrics
+# Apply to databases created by db.script before named_metrics and credentials existed.
+
+USE metricdb;
+CREATE TABLE named_metrics(\
+    Id INT AUTO_INCREMENT PRIMARY KEY, \
+    app_uuid VARCHAR(255), \
+    instance_uuid VARCHAR(255), \
+    name VARCHAR(64), \
+    value DOUBLE, \
+    created_at INT UNSIGNED, \
+    INDEX (app_uuid, name, created_at)\
+);
+
+USE policydb;
+ALTER TABLE policies ADD COLUMN metric_name VARCHAR(64) NOT NULL DEFAULT '' AFTER metric_type;
+CREATE TABLE credentials(\
+    Id INT AUTO_INCREMENT PRIMARY KEY, \
+    app_uuid VARCHAR(255), \
+    token_hash CHAR(64), \
+    created_at INT UNSIGNED \
+);
@@ -0,0 +1,13 @@
+# Prometheus scrape configs
+
+USE policydb;
+CREATE TABLE scrapes(\
+    Id INT AUTO_INCREMENT PRIMARY KEY, \
+    app_uuid VARCHAR(255), \
+    scrape_uuid VARCHAR(255), \
+    url VARCHAR(1024), \
+    scrape_interval SMALLINT UNSIGNED, \
+    per_instance TINYINT UNSIGNED, \
+    mappings TEXT, \
+    deleted TINYINT UNSIGNED \
+);
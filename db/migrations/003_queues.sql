@@ -0,0 +1,16 @@
+# RabbitMQ queue metrics
+
+USE policydb;
+ALTER TABLE policies ADD COLUMN per_instance TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER metric_name;
+CREATE TABLE queues(\
+    Id INT AUTO_INCREMENT PRIMARY KEY, \
+    app_uuid VARCHAR(255), \
+    queue_uuid VARCHAR(255), \
+    api_url VARCHAR(1024), \
+    vhost VARCHAR(255), \
+    queue VARCHAR(255), \
+    username VARCHAR(255), \
+    password VARCHAR(255), \
+    poll_interval SMALLINT UNSIGNED, \
+    deleted TINYINT UNSIGNED \
+);
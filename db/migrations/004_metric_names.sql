@@ -0,0 +1,7 @@
+# Policies refer to metrics by their name in the registry
+
+USE policydb;
+UPDATE policies SET metric_name = 'cpu' WHERE metric_name = '' AND metric_type = 0;
+UPDATE policies SET metric_name = 'mem' WHERE metric_name = '' AND metric_type = 1;
+UPDATE policies SET metric_name = 'throughput' WHERE metric_name = '' AND metric_type = 2;
+UPDATE policies SET metric_name = 'latency' WHERE metric_name = '' AND metric_type = 3;
@@ -0,0 +1,5 @@
+# Disk usage in the metrics table
+# Existing rows keep a NULL disk, the api reports it as 0.
+
+USE metricdb;
+ALTER TABLE metrics ADD COLUMN disk FLOAT UNSIGNED AFTER mem;
@@ -0,0 +1,5 @@
+# Memory as percentage of the app's memory quota
+# Existing rows keep a NULL mem_pct.
+
+USE metricdb;
+ALTER TABLE metrics ADD COLUMN mem_pct FLOAT UNSIGNED AFTER mem;
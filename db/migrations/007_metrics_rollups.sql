@@ -0,0 +1,23 @@
+# Rollups and retention of the metrics table
+
+USE metricdb;
+ALTER TABLE metrics ADD INDEX (app_uuid, created_at), ADD INDEX (created_at);
+CREATE TABLE metrics_1m(\
+    Id INT AUTO_INCREMENT PRIMARY KEY, \
+    app_uuid VARCHAR(255), \
+    instance_uuid VARCHAR(255), \
+    bucket INT UNSIGNED, \
+    count INT UNSIGNED, \
+    cpu_avg FLOAT UNSIGNED, cpu_min FLOAT UNSIGNED, cpu_max FLOAT UNSIGNED, \
+    mem_avg FLOAT UNSIGNED, mem_min FLOAT UNSIGNED, mem_max FLOAT UNSIGNED, \
+    mem_pct_avg FLOAT UNSIGNED, mem_pct_min FLOAT UNSIGNED, mem_pct_max FLOAT UNSIGNED, \
+    disk_avg FLOAT UNSIGNED, disk_min FLOAT UNSIGNED, disk_max FLOAT UNSIGNED, \
+    UNIQUE (app_uuid, instance_uuid, bucket), \
+    INDEX (app_uuid, bucket), \
+    INDEX (bucket)\
+);
+CREATE TABLE metrics_1h LIKE metrics_1m;
+CREATE TABLE rollups(\
+    table_name VARCHAR(64) PRIMARY KEY, \
+    rolled_until INT UNSIGNED\
+);
@@ -0,0 +1,4 @@
+# Dry-run mode of apps: the engine records its decisions without scaling
+
+USE policydb;
+ALTER TABLE apps ADD COLUMN dry_run TINYINT UNSIGNED NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+# Target-tracking policies
+
+USE policydb;
+ALTER TABLE policies ADD COLUMN policy_type VARCHAR(16) NOT NULL DEFAULT 'threshold' AFTER policy_uuid;
+ALTER TABLE policies ADD COLUMN target_value FLOAT NOT NULL DEFAULT 0 AFTER instances_in;
+ALTER TABLE policies ADD COLUMN tolerance FLOAT NOT NULL DEFAULT 0 AFTER target_value;
@@ -0,0 +1,11 @@
+# Step policies: bands of thresholds with their own adjustment
+
+USE policydb;
+CREATE TABLE policy_steps(\
+    Id INT AUTO_INCREMENT PRIMARY KEY, \
+    policy_uuid VARCHAR(255), \
+    scale_type VARCHAR(8), \
+    threshold FLOAT, \
+    instances SMALLINT UNSIGNED, \
+    INDEX (policy_uuid)\
+);
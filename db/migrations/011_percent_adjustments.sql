@@ -0,0 +1,5 @@
+# Adjustments in percent of the current instances
+
+USE policydb;
+ALTER TABLE policies ADD COLUMN adjustment_type VARCHAR(16) NOT NULL DEFAULT 'count' AFTER tolerance;
+ALTER TABLE policies ADD COLUMN min_adjustment SMALLINT UNSIGNED NOT NULL DEFAULT 0 AFTER adjustment_type;
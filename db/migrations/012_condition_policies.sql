@@ -0,0 +1,5 @@
+# Condition policies: boolean expressions over several metrics
+
+USE policydb;
+ALTER TABLE policies ADD COLUMN out_condition VARCHAR(1024) NOT NULL DEFAULT '' AFTER min_adjustment;
+ALTER TABLE policies ADD COLUMN in_condition VARCHAR(1024) NOT NULL DEFAULT '' AFTER out_condition;
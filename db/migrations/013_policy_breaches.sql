@@ -0,0 +1,13 @@
+# Breach requirements of policies and their state across evaluations
+
+USE policydb;
+ALTER TABLE policies ADD COLUMN breach_evaluations SMALLINT UNSIGNED NOT NULL DEFAULT 0 AFTER in_condition;
+ALTER TABLE policies ADD COLUMN breach_duration INT UNSIGNED NOT NULL DEFAULT 0 AFTER breach_evaluations;
+CREATE TABLE breaches(\
+    policy_uuid VARCHAR(255) PRIMARY KEY, \
+    app_uuid VARCHAR(255), \
+    scale_type VARCHAR(8) NOT NULL DEFAULT '', \
+    evaluations INT UNSIGNED NOT NULL DEFAULT 0, \
+    since INT UNSIGNED NOT NULL DEFAULT 0, \
+    INDEX (app_uuid)\
+);
@@ -0,0 +1,9 @@
+# Cooldowns per direction
+
+USE policydb;
+ALTER TABLE apps ADD COLUMN next_out_time INT NOT NULL DEFAULT 0 AFTER next_time;
+ALTER TABLE apps ADD COLUMN next_in_time INT NOT NULL DEFAULT 0 AFTER next_out_time;
+UPDATE apps SET next_out_time = COALESCE(next_time, 0), next_in_time = COALESCE(next_time, 0);
+ALTER TABLE apps DROP COLUMN next_time;
+ALTER TABLE policies ADD COLUMN cooldown_out SMALLINT UNSIGNED NOT NULL DEFAULT 0 AFTER cooldown_period;
+ALTER TABLE policies ADD COLUMN cooldown_in SMALLINT UNSIGNED NOT NULL DEFAULT 0 AFTER cooldown_out;
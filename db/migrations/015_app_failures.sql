@@ -0,0 +1,7 @@
+# Failure backoff and at limit state of apps
+
+USE policydb;
+ALTER TABLE apps ADD COLUMN failures INT UNSIGNED NOT NULL DEFAULT 0 AFTER dry_run;
+ALTER TABLE apps ADD COLUMN last_error VARCHAR(255) NOT NULL DEFAULT '' AFTER failures;
+ALTER TABLE apps ADD COLUMN failed_at INT NOT NULL DEFAULT 0 AFTER last_error;
+ALTER TABLE apps ADD COLUMN at_limit VARCHAR(8) NOT NULL DEFAULT '' AFTER failed_at;
@@ -0,0 +1,4 @@
+# Flapping state of apps
+
+USE policydb;
+ALTER TABLE apps ADD COLUMN flapping_until INT NOT NULL DEFAULT 0 AFTER at_limit;
@@ -0,0 +1,7 @@
+# Protection of apps against scaling in
+
+USE policydb;
+ALTER TABLE apps ADD COLUMN max_in_per_hour SMALLINT UNSIGNED NOT NULL DEFAULT 0 AFTER flapping_until;
+ALTER TABLE apps ADD COLUMN min_instance_age INT NOT NULL DEFAULT 0 AFTER max_in_per_hour;
+ALTER TABLE apps ADD COLUMN protection_windows VARCHAR(255) NOT NULL DEFAULT '' AFTER min_instance_age;
+ALTER TABLE apps ADD COLUMN protection_timezone VARCHAR(64) NOT NULL DEFAULT 'UTC' AFTER protection_windows;
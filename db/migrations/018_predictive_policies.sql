@@ -0,0 +1,17 @@
+# Predictive policies and the forecasts they scale on
+
+USE policydb;
+ALTER TABLE policies ADD COLUMN predictive TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER measurement_period;
+ALTER TABLE policies ADD COLUMN forecast_lead INT UNSIGNED NOT NULL DEFAULT 0 AFTER predictive;
+
+USE metricdb;
+CREATE TABLE forecasts(\
+    app_uuid VARCHAR(255), \
+    name VARCHAR(64), \
+    aggregation VARCHAR(8), \
+    bucket INT UNSIGNED, \
+    step INT UNSIGNED, \
+    value DOUBLE, \
+    created_at INT UNSIGNED, \
+    PRIMARY KEY (app_uuid, name, aggregation, bucket)\
+);
@@ -0,0 +1,4 @@
+# Retention of the named_metrics table
+
+USE metricdb;
+ALTER TABLE named_metrics ADD INDEX (created_at);
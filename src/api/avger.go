@@ -0,0 +1,59 @@
+package main
+
+import (
+    "log"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// AvgerClient forwards metrics to the avger using the same line protocol as the monitor.
+// The connection is opened lazily and re-opened after a write failure.
+type AvgerClient struct {
+    sync.Mutex
+    addr string
+    conn net.Conn
+}
+
+// SendCustom writes one line "app_uuid instance_uuid name=value ..." per instance.
+func (ac *AvgerClient) SendCustom(app_uuid string, metrics []CustomMetric) error {
+    lines := make(map[string][]string) // instance_uuid -> name=value pairs
+    for _, m := range metrics {
+        lines[m.Instance_uuid] = append(lines[m.Instance_uuid], m.Name + "=" + strconv.FormatFloat(m.Value, 'f', -1, 64))
+    }
+
+    var msg string
+    for instance_uuid, pairs := range lines {
+        if instance_uuid == "" {
+            instance_uuid = "-"
+        }
+        msg = msg + app_uuid + " " + instance_uuid + " " + strings.Join(pairs, " ") + "\n"
+    }
+
+    return ac.write([]byte(msg))
+}
+
+func (ac *AvgerClient) write(msg []byte) error {
+    ac.Lock()
+    defer ac.Unlock()
+
+    if ac.conn == nil {
+        conn, err := net.Dial("tcp", ac.addr)
+        if err != nil {
+            log.Println("Cannot connect to the avger:", ac.addr, err)
+            return err
+        }
+        ac.conn = conn
+    }
+
+    _, err := ac.conn.Write(msg)
+    if err != nil {
+        log.Println("Cannot write to the avger:", err)
+        ac.conn.Close()
+        ac.conn = nil
+        return err
+    }
+
+    return nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/gorilla/mux"
+
+    "registry"
+    "scaling"
+)
+
+const MAX_BACKTEST_PERIOD = 31 * 24 * 3600 // seconds
+const MAX_MEASUREMENT_PERIOD = 3600 // seconds, as in the avger
+
+// BacktestRequest proposes policies and bounds for an app, to be replayed
+// against its recorded metrics in [Start, End). Bounds and policies which are
+// left out are those of the app, as is its protection, field by field.
+// Predictive policies are replayed against the forecasts the forecaster made
+// at the time, while they are kept.
+type BacktestRequest struct {
+    Start int
+    End int
+    Interval int // seconds between evaluations, as the director would run them
+    Min_instances int
+    Max_instances int
+    Instances int // at Start, Min_instances if 0
+    Policies []scaling.Policy
+    Max_in_per_hour *int // protection of the app for each field left out
+    Min_instance_age *int
+    Protection_windows *string
+    Protection_timezone *string
+    Flap_window int // seconds, scaling.DefaultFlapWindow if 0, as the engine is configured
+    Flap_reversals int // scaling.DefaultFlapReversals if 0
+    Flap_hold int // seconds, scaling.DefaultFlapHold if 0
+}
+
+type BacktestPoint struct {
+    Time int
+    Instances int
+}
+
+type BacktestDecision struct {
+    Time int
+    Decision scaling.Decision
+    Num_before int
+    Num_after int
+}
+
+type BacktestResult struct {
+    Timeline []BacktestPoint
+    Decisions []BacktestDecision
+    Flapping []int // unix times the app was found flapping, its scaling in held
+}
+
+// Backtest replays the samples through the same evaluation as the engine:
+// the decisions of all policies resolved into one once their breaches last long
+// enough, then the cooldown of the winning policy in the direction it scaled,
+// the damping of flapping and the protection of the app against scaling in.
+func Backtest(req BacktestRequest, protection scaling.Protection, samples map[string][]scaling.Sample, forecasts map[string][]Forecast) BacktestResult {
+    result := BacktestResult{Timeline: []BacktestPoint{}, Decisions: []BacktestDecision{}, Flapping: []int{}}
+    var history []scaling.Scaling
+    flapping_until := 0
+
+    num := req.Instances
+    next_times := map[string]int{scaling.ScaleOut: 0, scaling.ScaleIn: 0}
+    breaches := make([]scaling.Breach, len(req.Policies))
+    for t := req.Start; t < req.End; t = t + req.Interval {
+        if t >= next_times[scaling.ScaleOut] || t >= next_times[scaling.ScaleIn] { // as the director enqueues apps
+            if flapping_until <= t && scaling.Reversals(history, t - req.Flap_window) >= req.Flap_reversals {
+                flapping_until = t + req.Flap_hold
+                if flapping_until > next_times[scaling.ScaleIn] {
+                    next_times[scaling.ScaleIn] = flapping_until
+                }
+                result.Flapping = append(result.Flapping, t)
+            }
+            holds, limits := protection.Restrict(t, history)
+            e := scaling.Evaluator{
+                Policies: req.Policies,
+                Observe: func(policy scaling.Policy, metric registry.Metric) (float64, string, int, error) {
+                    value, count := scaling.Aggregate(samples[metric.Name], t - policy.Measurement_period, t, metric.Aggregation)
+                    return value, metric.Aggregation, count, nil
+                },
+                Forecast: func(policy scaling.Policy, metric registry.Metric, at int) (float64, bool, error) {
+                    f, ok := ForecastAt(forecasts[metric.Name + " " + metric.Aggregation], at)
+                    return f.Value, ok, nil
+                },
+                Instances: func() (int, error) {
+                    return num, nil
+                },
+                Breaches: breaches,
+                Now: t,
+                Cooldowns: next_times,
+                Holds: holds,
+                Limits: limits}
+
+            e.Run(func(d scaling.Decision) bool {
+                num_after, err := scaling.Target(d, num, req.Min_instances, req.Max_instances)
+                if err != nil {
+                    return false
+                }
+                result.Decisions = append(result.Decisions, BacktestDecision{Time: t, Decision: d, Num_before: num, Num_after: num_after})
+                history = append(history, scaling.Scaling{Time: t, Scale: d.Scale, Num_before: num, Num_after: num_after})
+                num = num_after
+                next_times[d.Scale] = t + d.Cooldown
+                return true
+            })
+        }
+        result.Timeline = append(result.Timeline, BacktestPoint{Time: t, Instances: num})
+    }
+
+    return result
+}
+
+// LoadSamples reads the recorded samples of every metric of the policies,
+// from the longest measurement period before start until end. Collector
+// metrics come from the finest table whose rows are no wider than the
+// shortest measurement period, or from the rollups where the finer tables no
+// longer go back to start: their averages then stand for the samples.
+func LoadSamples(app_uuid string, policies []scaling.Policy, start int, end int) (map[string][]scaling.Sample, error) {
+    samples := make(map[string][]scaling.Sample)
+
+    period, shortest := 0, 0
+    names := make(map[string]bool)
+    for _, p := range policies {
+        metrics, err := scaling.PolicyMetrics(p)
+        if err != nil {
+            return nil, err
+        }
+        for _, metric := range metrics {
+            names[metric.Name] = true
+        }
+        if p.Measurement_period > period {
+            period = p.Measurement_period
+        }
+        if shortest == 0 || p.Measurement_period < shortest {
+            shortest = p.Measurement_period
+        }
+    }
+    start = start - period
+
+    collector := false
+    for name := range names {
+        metric, _ := registry.Lookup(name)
+        if metric.Source == registry.SourceCollector {
+            collector = true
+            continue
+        }
+        err := api.mdb.EachCustom(app_uuid, name, start - 1, end, "", func(m CustomMetric) error {
+            samples[name] = append(samples[name], scaling.Sample{Time: m.Created_at, Instance_uuid: m.Instance_uuid, Value: m.Value})
+            return nil
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    if collector {
+        err := api.mdb.Each(app_uuid, start - 1, end, shortest, "", func(m Metric) error {
+            for name, value := range map[string]float64{"cpu": m.Cpu, "mem": m.Mem, "mem_pct": m.Mem_pct, "disk": m.Disk} {
+                if names[name] {
+                    samples[name] = append(samples[name], scaling.Sample{Time: m.Created_at, Instance_uuid: m.Instance_uuid, Value: value})
+                }
+            }
+            return nil
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return samples, nil
+}
+
+// proposedPolicy returns a policy proposed to a backtest as it would be added.
+func proposedPolicy(p scaling.Policy) Policy {
+    return Policy{
+        Policy_uuid: p.Policy_uuid,
+        Policy_type: p.Policy_type,
+        Metric_type: p.Metric_type,
+        Metric_name: p.Metric_name,
+        Per_instance: &p.Per_instance,
+        Upper_threshold: p.Upper_threshold,
+        Lower_threshold: p.Lower_threshold,
+        Instances_out: p.Instances_out,
+        Instances_in: p.Instances_in,
+        Target_value: p.Target_value,
+        Tolerance: p.Tolerance,
+        Steps: p.Steps,
+        Adjustment_type: p.Adjustment_type,
+        Min_adjustment: p.Min_adjustment,
+        Out_condition: &p.Out_condition,
+        In_condition: &p.In_condition,
+        Breach_evaluations: p.Breach_evaluations,
+        Breach_duration: p.Breach_duration,
+        Cooldown_period: p.Cooldown_period,
+        Cooldown_out: p.Cooldown_out,
+        Cooldown_in: p.Cooldown_in,
+        Measurement_period: p.Measurement_period,
+        Predictive: &p.Predictive,
+        Forecast_lead: p.Forecast_lead}
+}
+
+// BacktestHandler answers what the proposed policies would have done to the app.
+func BacktestHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    app_uuid := vars["app_uuid"]
+
+    var req BacktestRequest
+    err := json.NewDecoder(r.Body).Decode(&req)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    exist, err := api.pdb.IsExistApp(app_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+    if exist == false {
+        http.Error(w, ErrNotExist, http.StatusBadRequest)
+        return
+    }
+
+    // Bounds, policies and protection of the app by default
+    app, err := api.pdb.GetApp(app_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+    if req.Min_instances == 0 && req.Max_instances == 0 {
+        req.Min_instances, req.Max_instances = app.Min_instances, app.Max_instances
+    }
+    if req.Policies == nil {
+        policies, err := api.pdb.GetPolicies(app_uuid)
+        if err != nil {
+            log.Println(err)
+            http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+            return
+        }
+        for _, p := range policies {
+            req.Policies = append(req.Policies, scaling.Policy{
+                Policy_uuid: p.Policy_uuid,
+                Policy_type: p.Policy_type,
+                Metric_type: p.Metric_type,
+                Metric_name: p.Metric_name,
+                Per_instance: p.Per_instance != nil && *p.Per_instance,
+                Upper_threshold: p.Upper_threshold,
+                Lower_threshold: p.Lower_threshold,
+                Instances_out: p.Instances_out,
+                Instances_in: p.Instances_in,
+                Target_value: p.Target_value,
+                Tolerance: p.Tolerance,
+                Steps: p.Steps,
+                Adjustment_type: p.Adjustment_type,
+                Min_adjustment: p.Min_adjustment,
+                Out_condition: condition(p.Out_condition),
+                In_condition: condition(p.In_condition),
+                Breach_evaluations: p.Breach_evaluations,
+                Breach_duration: p.Breach_duration,
+                Cooldown_period: p.Cooldown_period,
+                Cooldown_out: p.Cooldown_out,
+                Cooldown_in: p.Cooldown_in,
+                Measurement_period: p.Measurement_period,
+                Predictive: p.Predictive != nil && *p.Predictive,
+                Forecast_lead: p.Forecast_lead})
+        }
+    }
+    if req.Max_in_per_hour == nil {
+        req.Max_in_per_hour = app.Max_in_per_hour
+    }
+    if req.Min_instance_age == nil {
+        req.Min_instance_age = app.Min_instance_age
+    }
+    if req.Protection_windows == nil {
+        req.Protection_windows = app.Protection_windows
+    }
+    if req.Protection_timezone == nil {
+        req.Protection_timezone = app.Protection_timezone
+    }
+    if req.Interval == 0 {
+        req.Interval = 60
+    }
+    if req.Flap_window == 0 {
+        req.Flap_window = scaling.DefaultFlapWindow
+    }
+    if req.Flap_reversals == 0 {
+        req.Flap_reversals = scaling.DefaultFlapReversals
+    }
+    if req.Flap_hold == 0 {
+        req.Flap_hold = scaling.DefaultFlapHold
+    }
+    if req.Instances == 0 {
+        req.Instances = req.Min_instances
+    }
+
+    if req.End <= req.Start || req.End - req.Start > MAX_BACKTEST_PERIOD || req.Interval < 1 || (req.End - req.Start) / req.Interval >= MaxPoints {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+    if req.Min_instances < 1 || req.Max_instances < req.Min_instances || req.Instances < 0 || req.Flap_window < 0 || req.Flap_reversals < 0 || req.Flap_hold < 0 {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+    for _, p := range req.Policies {
+        if _, err := scaling.PolicyMetrics(p); err != nil {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        // As the policies are added, see AddPolicy
+        policy := proposedPolicy(p)
+        if err := validatePolicy(policy); err != nil {
+            log.Println(err)
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        if err := validateRequired(policy); err != nil {
+            log.Println(err)
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        // Left to the engine for the stored policies, needed to replay them
+        threshold := p.Policy_type == "" || p.Policy_type == scaling.PolicyThreshold
+        if threshold && p.Lower_threshold > p.Upper_threshold || p.Measurement_period <= 0 || p.Measurement_period > MAX_MEASUREMENT_PERIOD || p.Cooldown_period < 0 {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+    }
+
+    protection := scaling.Protection{Max_in_per_hour: *req.Max_in_per_hour, Min_instance_age: *req.Min_instance_age}
+    if protection.Max_in_per_hour < 0 || protection.Min_instance_age < 0 {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+    protection.Windows, err = scaling.ParseWindows(*req.Protection_windows)
+    if err != nil {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+    protection.Location, err = time.LoadLocation(*req.Protection_timezone)
+    if err != nil {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    samples, err := LoadSamples(app_uuid, req.Policies, req.Start, req.End)
+    if err != nil {
+        log.Println("Error occurs when loading samples: ", err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    forecasts, err := LoadForecasts(app_uuid, req.Policies, req.Start, req.End)
+    if err != nil {
+        log.Println("Error occurs when loading forecasts: ", err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    result, err := json.Marshal(Backtest(req, protection, samples, forecasts))
+    if err != nil {
+        log.Println("Error occurs when marshaling: ", err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Write(result)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "log"
+    "mime"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// Formats of the responses of list handlers, negotiated by the Accept header
+const (
+    FormatJSON = "application/json"
+    FormatCSV = "text/csv"
+    FormatNDJSON = "application/x-ndjson"
+)
+
+// Negotiate returns the first format of the Accept header the API can write, JSON by default.
+func Negotiate(r *http.Request) string {
+    for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+        media, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+        if err != nil {
+            continue
+        }
+        switch media {
+            case FormatJSON, "*/*":
+                return FormatJSON
+            case FormatCSV:
+                return FormatCSV
+            case FormatNDJSON, "application/ndjson", "application/jsonlines":
+                return FormatNDJSON
+        }
+    }
+    return FormatJSON
+}
+
+// Record is a row which can be written as CSV.
+type Record interface {
+    Record() []string
+}
+
+// RowWriter streams rows as CSV or NDJSON, flushing every flushEvery rows so
+// that the response never piles up in memory.
+type RowWriter struct {
+    w http.ResponseWriter
+    format string
+    csv *csv.Writer
+    encoder *json.Encoder
+    rows int
+    sent bool // whether the response has started, after which errors can't be reported
+}
+
+const flushEvery = 1000
+
+func NewRowWriter(w http.ResponseWriter, format string, header []string) *RowWriter {
+    rw := &RowWriter{w: w, format: format}
+    w.Header().Set("Content-Type", format)
+    if format == FormatCSV {
+        rw.csv = csv.NewWriter(rw)
+        rw.csv.Write(header)
+    } else {
+        rw.encoder = json.NewEncoder(rw)
+    }
+    return rw
+}
+
+func (rw *RowWriter) Write(p []byte) (int, error) {
+    rw.sent = true
+    return rw.w.Write(p)
+}
+
+func (rw *RowWriter) WriteRow(row Record) error {
+    var err error
+    if rw.csv != nil {
+        err = rw.csv.Write(row.Record())
+    } else {
+        err = rw.encoder.Encode(row) // one JSON object per line
+    }
+    if err != nil {
+        return err
+    }
+
+    rw.rows = rw.rows + 1
+    if rw.rows % flushEvery == 0 {
+        rw.Flush()
+    }
+    return nil
+}
+
+// Close flushes the remaining rows, or answers an error if none was sent yet.
+func (rw *RowWriter) Close(err error) {
+    if err == nil {
+        rw.Flush()
+        return
+    }
+    if rw.sent == false {
+        http.Error(rw.w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+    log.Println("Error occurs when streaming the response: ", err) // the client sees a truncated response
+}
+
+func (rw *RowWriter) Flush() {
+    if rw.csv != nil {
+        rw.csv.Flush()
+    }
+    if f, ok := rw.w.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+var MetricHeader = []string{"instance_uuid", "created_at", "cpu", "mem", "mem_pct", "disk"}
+
+func (m Metric) Record() []string {
+    return []string{m.Instance_uuid, strconv.Itoa(m.Created_at), formatFloat(m.Cpu), formatFloat(m.Mem), formatFloat(m.Mem_pct), formatFloat(m.Disk)}
+}
+
+var CustomMetricHeader = []string{"instance_uuid", "name", "value", "created_at"}
+
+func (m CustomMetric) Record() []string {
+    return []string{m.Instance_uuid, m.Name, formatFloat(m.Value), strconv.Itoa(m.Created_at)}
+}
+
+var MetadataHeader = []string{"created_at", "scale", "metric", "value", "threshold", "status", "instances_out", "percent", "num_before", "num_after", "resolution", "forecast", "flapping", "reversals", "dry_run"}
+
+func (m Metadata) Record() []string {
+    return []string{strconv.Itoa(m.CreatedAt), m.Scale, m.Metric, formatFloat(m.Value), formatFloat(m.Threshold), strconv.Itoa(m.Status), strconv.Itoa(m.InstancesOut), strconv.Itoa(m.Percent), strconv.Itoa(m.NumBefore), strconv.Itoa(m.NumAfter), m.Resolution, formatFloat(m.Forecast), strconv.FormatBool(m.Flapping), strconv.Itoa(m.Reversals), strconv.FormatBool(m.DryRun)}
+}
+
+func formatFloat(v float64) string {
+    return strconv.FormatFloat(v, 'f', -1, 64)
+}
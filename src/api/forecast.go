@@ -0,0 +1,144 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/mux"
+
+    "registry"
+    "scaling"
+)
+
+// ForecastDB reads the forecasts the forecaster stores in MetricDB, whatever
+// the backend of the samples.
+type ForecastDB struct {
+    db *sql.DB
+}
+
+// Forecast is a predicted bucket of a metric of an app
+type Forecast struct {
+    Time int // start of the bucket
+    Step int // seconds, width of the bucket
+    Value float64
+    Created_at int // when it was forecast, the latest forecast before the bucket passed
+}
+
+// Get returns the forecasts of the metric of an app whose buckets start in [start, end).
+func (fdb *ForecastDB) Get(app_uuid string, name string, aggregation string, start int, end int) ([]Forecast, error) {
+    forecasts := []Forecast{}
+    rows, err := fdb.db.Query("SELECT bucket, step, value, created_at FROM forecasts WHERE app_uuid = ? AND name = ? AND aggregation = ? AND bucket >= ? AND bucket < ? ORDER BY bucket", app_uuid, name, aggregation, start, end)
+    if err != nil {
+        log.Println("Error occurs when querying forecasts: ", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var f Forecast
+        if err := rows.Scan(&f.Time, &f.Step, &f.Value, &f.Created_at); err != nil {
+            log.Println("Error occurs when parsing row: ", err)
+            return nil, err
+        }
+        forecasts = append(forecasts, f)
+    }
+    return forecasts, rows.Err()
+}
+
+// ForecastAt returns the forecast whose bucket holds the unix time at, among forecasts in chronological order.
+func ForecastAt(forecasts []Forecast, at int) (Forecast, bool) {
+    i := sort.Search(len(forecasts), func(i int) bool { return forecasts[i].Time > at })
+    if i == 0 || forecasts[i - 1].Time + forecasts[i - 1].Step <= at {
+        return Forecast{}, false
+    }
+    return forecasts[i - 1], true
+}
+
+// LoadForecasts reads the forecasts of the metric of every predictive policy
+// an evaluation in [start, end) would look at, by metric name and aggregation.
+func LoadForecasts(app_uuid string, policies []scaling.Policy, start int, end int) (map[string][]Forecast, error) {
+    forecasts := make(map[string][]Forecast)
+    for _, p := range policies {
+        if p.Predictive == false {
+            continue
+        }
+        metric, ok := scaling.PolicyMetric(p)
+        if ok == false {
+            continue
+        }
+        key := metric.Name + " " + metric.Aggregation
+        if _, done := forecasts[key]; done {
+            continue
+        }
+        // Buckets, at most a day wide, starting before start may hold it
+        list, err := api.fdb.Get(app_uuid, metric.Name, metric.Aggregation, start - 86400, end + scaling.ForecastLead(p))
+        if err != nil {
+            return nil, err
+        }
+        forecasts[key] = list
+    }
+    return forecasts, nil
+}
+
+// GetForecastsHandler answers the forecasts of a metric of the app, from now
+// on for the next day by default, for them to be reviewed against the
+// series of the metric.
+func GetForecastsHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    r.ParseForm()
+    app_uuid := vars["app_uuid"]
+    var err error
+
+    metric, ok := registry.Lookup(r.Form.Get("metric"))
+    if ok == false {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+    if agg := r.Form.Get("aggregation"); agg != "" {
+        if registry.IsValidAggregation(agg) == false {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        metric.Aggregation = agg
+    }
+
+    start := int(time.Now().Unix()) // default: now
+    if i, ok := r.Form["start"]; ok {
+        start, err = strconv.Atoi(i[0])
+        if err != nil {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+    }
+
+    end := start + 86400 // default: a day later
+    if i, ok := r.Form["end"]; ok {
+        end, err = strconv.Atoi(i[0])
+        if err != nil {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+    }
+
+    forecasts, err := api.fdb.Get(app_uuid, metric.Name, metric.Aggregation, start, end)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    result, err := json.Marshal(forecasts)
+    if err != nil {
+        log.Println("Error occurs when marshaling: ", err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(result)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "registry"
+)
+
+// Grafana SimpleJSON datasource, mounted under /grafana.
+//
+// A target is "<app_uuid>/<metric>[/<agg>[/instance]]", e.g. "0a1b.../cpu/p95/instance"
+// returns the 95th percentile of cpu of each instance. An annotation query is
+// a comma separated list of app_uuid whose scaling history is shown.
+
+type GrafanaRange struct {
+    From time.Time
+    To time.Time
+}
+
+type GrafanaTarget struct {
+    Target string
+    RefId string
+    Type string
+}
+
+type GrafanaQuery struct {
+    Range GrafanaRange
+    IntervalMs int
+    MaxDataPoints int
+    Targets []GrafanaTarget
+}
+
+type GrafanaAnnotation struct {
+    Name string `json:"name"`
+    Datasource string `json:"datasource"`
+    IconColor string `json:"iconColor"`
+    Enable bool `json:"enable"`
+    Query string `json:"query"`
+}
+
+type GrafanaAnnotationQuery struct {
+    Range GrafanaRange
+    Annotation GrafanaAnnotation
+}
+
+type GrafanaSeries struct {
+    Target string `json:"target"`
+    Datapoints [][]interface{} `json:"datapoints"` // [value or null, unix time in ms]
+}
+
+type GrafanaEvent struct {
+    Annotation GrafanaAnnotation `json:"annotation"`
+    Time int64 `json:"time"` // unix time in ms
+    Title string `json:"title"`
+    Tags []string `json:"tags"`
+    Text string `json:"text"`
+}
+
+// ParseTarget turns a Grafana target into a series query, without its range.
+func ParseTarget(target string) (SeriesQuery, bool) {
+    parts := strings.Split(target, "/")
+    if len(parts) < 2 || len(parts) > 4 || parts[0] == "" {
+        return SeriesQuery{}, false
+    }
+
+    sq := SeriesQuery{App_uuid: parts[0], Metric: parts[1], Agg: registry.AggAvg}
+    if IsPolicyMetricName(sq.Metric) == false {
+        return sq, false
+    }
+    if len(parts) > 2 {
+        if registry.IsValidAggregation(parts[2]) == false || parts[2] == registry.AggLast {
+            return sq, false
+        }
+        sq.Agg = parts[2]
+    }
+    if len(parts) > 3 {
+        if parts[3] != "instance" {
+            return sq, false
+        }
+        sq.By_instance = true
+    }
+    return sq, true
+}
+
+// GrafanaTestHandler answers the connection test of the datasource.
+func GrafanaTestHandler(w http.ResponseWriter, r *http.Request) {
+    fmt.Fprint(w, "OK")
+}
+
+// GrafanaSearchHandler lists the targets "<app_uuid>/<metric>" which contain the searched text.
+func GrafanaSearchHandler(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Target string
+    }
+    err := json.NewDecoder(r.Body).Decode(&req)
+    if err != nil {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    apps, err := api.pdb.GetApps()
+    if err != nil {
+        log.Println("Error occurs when getting apps: ", err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    targets := []string{}
+    for _, app := range apps {
+        for _, m := range registry.All() {
+            target := app.App_uuid + "/" + m.Name
+            if strings.Contains(target, req.Target) || strings.Contains(app.Name, req.Target) {
+                targets = append(targets, target)
+            }
+        }
+    }
+
+    result, err := json.Marshal(targets)
+    if err != nil {
+        log.Println("Error occurs when marshaling: ", err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Write(result)
+}
+
+// GrafanaQueryHandler returns a time series per target, or per instance of targets grouped by instance.
+func GrafanaQueryHandler(w http.ResponseWriter, r *http.Request) {
+    var req GrafanaQuery
+    err := json.NewDecoder(r.Body).Decode(&req)
+    if err != nil {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    start := int(req.Range.From.Unix())
+    end := int(req.Range.To.Unix())
+    if end <= start {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    // Buckets as wide as Grafana's interval, but no more than it can draw
+    step := req.IntervalMs / 1000
+    if step < 1 {
+        step = 1
+    }
+    max_points := req.MaxDataPoints
+    if max_points <= 0 || max_points > MaxPoints {
+        max_points = MaxPoints
+    }
+    if (end - start) / step >= max_points {
+        step = (end - start) / max_points + 1
+    }
+
+    result := []GrafanaSeries{}
+    for _, t := range req.Targets {
+        if t.Target == "" {
+            continue // target not chosen yet
+        }
+        sq, ok := ParseTarget(t.Target)
+        if ok == false {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        sq.Start, sq.End, sq.Step = start, end, step
+
+        series, err := api.mdb.GetSeries(sq)
+        if err != nil {
+            log.Println("Error occurs when getting metric: ", err)
+            http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+            return
+        }
+
+        for _, s := range series {
+            gs := GrafanaSeries{Target: t.Target, Datapoints: [][]interface{}{}}
+            if sq.By_instance {
+                gs.Target = t.Target + " " + s.Instance_uuid
+            }
+            for _, p := range s.Points {
+                var v interface{}
+                if p.Value != nil {
+                    v = *p.Value
+                }
+                gs.Datapoints = append(gs.Datapoints, []interface{}{v, int64(p.Time) * 1000})
+            }
+            result = append(result, gs)
+        }
+    }
+
+    body, err := json.Marshal(result)
+    if err != nil {
+        log.Println("Error occurs when marshaling: ", err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Write(body)
+}
+
+// GrafanaAnnotationsHandler returns the scaling history of the apps in the annotation query.
+func GrafanaAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+    var req GrafanaAnnotationQuery
+    err := json.NewDecoder(r.Body).Decode(&req)
+    if err != nil {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    start := int(req.Range.From.Unix())
+    end := int(req.Range.To.Unix())
+
+    events := []GrafanaEvent{}
+    for _, app_uuid := range strings.Split(req.Annotation.Query, ",") {
+        app_uuid = strings.TrimSpace(app_uuid)
+        if app_uuid == "" {
+            continue
+        }
+
+        histories, err := api.hdb.Get(app_uuid, start, end)
+        if err != nil {
+            log.Println("Error occurs when getting history: ", err)
+            http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+            return
+        }
+
+        for _, h := range histories {
+            e := GrafanaEvent{
+                Annotation: req.Annotation,
+                Time: int64(h.CreatedAt) * 1000,
+                Title: "Scale " + h.Scale,
+                Tags: []string{"scale_" + h.Scale, h.Metric},
+                Text: fmt.Sprintf("%s: %s %v, threshold %v, %d instances, %d after", app_uuid, h.Metric, h.Value, h.Threshold, h.InstancesOut, h.NumAfter)}
+            if h.Flapping {
+                e.Title = "Flapping"
+                e.Tags = []string{"flapping"}
+                e.Text = fmt.Sprintf("%s: %d reversals, scaling in held", app_uuid, h.Reversals)
+            }
+            if h.Percent != 0 {
+                e.Text = e.Text + fmt.Sprintf(" (%d%%)", h.Percent)
+            }
+            if h.Forecast != 0 {
+                e.Text = e.Text + fmt.Sprintf(", on forecast %v", h.Forecast)
+                e.Tags = append(e.Tags, "predictive")
+            }
+            if h.Status == 0 {
+                e.Title = e.Title + " failed"
+                e.Tags = append(e.Tags, "failed")
+            }
+            if h.DryRun {
+                e.Title = e.Title + " (dry run)"
+                e.Tags = append(e.Tags, "dry_run")
+            }
+            events = append(events, e)
+        }
+    }
+
+    body, err := json.Marshal(events)
+    if err != nil {
+        log.Println("Error occurs when marshaling: ", err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Write(body)
+}
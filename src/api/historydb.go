@@ -14,12 +14,27 @@ type HistoryDB struct {
 
 func (hdb *HistoryDB) Get(app_uuid string, start int, end int) ([]Metadata, error){
     var result []Metadata
-    q := "SELECT metadata FROM event WHERE actor_name = $1 AND actee = $2 AND created_at > $3 AND created_at < $4 ORDER BY created_at"
+
+    err := hdb.Each(app_uuid, start, end, func(m Metadata) error {
+        result = append(result, m)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return result, nil
+}
+
+// Each calls fn with the scaling events one row at a time, so that they can
+// be streamed without being held in memory. It stops at the first error of fn.
+func (hdb *HistoryDB) Each(app_uuid string, start int, end int, fn func(Metadata) error) error {
+    q := "SELECT metadata FROM event WHERE actor_name = $1 AND actee = $2 AND created_at > to_timestamp($3) AND created_at < to_timestamp($4) ORDER BY created_at"
     
     rows, err := hdb.db.Query(q, "citusscaler", app_uuid, start, end)
     if err != nil {
         log.Println("Error occurs when querying against history database: ", err)
-        return nil, err
+        return err
     }
     defer rows.Close()
 
@@ -28,23 +43,25 @@ func (hdb *HistoryDB) Get(app_uuid string, start int, end int) ([]Metadata, erro
         err := rows.Scan(&metadata)
         if err != nil {
             log.Println("Error occurs when parsing row: ", err)
-            return nil, err
+            return err
         }
 
         var m Metadata
-        err1 := json.Unmarshal([]byte(metadata), m)
+        err1 := json.Unmarshal([]byte(metadata), &m)
         if err1 != nil {
             log.Println("Error occuers when decoding metadata:", err1)
-            return nil, err1
+            return err1
         }
         
-        result = append(result, m)
+        if err := fn(m); err != nil {
+            return err
+        }
     }
 
     if err := rows.Err(); err != nil {
         log.Println("Error occurs when parsing rows: ", err)
-        return nil, err
+        return err
     }
 
-    return result, nil
+    return nil
 }
\ No newline at end of file
@@ -0,0 +1,159 @@
+package main
+
+import (
+    "bytes"
+    "log"
+    "net/http"
+    "time"
+
+    "influx"
+    "registry"
+)
+
+// MetricStore is where the samples of apps are kept.
+type MetricStore interface {
+    Get(app_uuid string, start int, end int, instance_uuid string) ([]Metric, error)
+    GetSeries(sq SeriesQuery) ([]Series, error)
+    AddCustom(app_uuid string, metrics []CustomMetric) error
+    GetCustom(app_uuid string, name string, start int, end int, instance_uuid string) ([]CustomMetric, error)
+    Each(app_uuid string, start int, end int, step int, instance_uuid string, fn func(Metric) error) error
+    EachCustom(app_uuid string, name string, start int, end int, instance_uuid string, fn func(CustomMetric) error) error
+}
+
+// InfluxDB keeps the samples in an InfluxDB compatible database, in the
+// layout of the influx package the monitor writes. Retention is left to
+// InfluxDB's own retention policies, the rollup tables of MetricDB aren't used.
+type InfluxDB struct {
+    client *influx.Client
+}
+
+func NewInfluxDB(c map[string]string) *InfluxDB {
+    // No overall timeout: chunked responses of long ranges are streamed
+    client := &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: 30 * time.Second}}
+    return &InfluxDB{client: influx.NewClient(c, client)}
+}
+
+func (idb *InfluxDB) Get(app_uuid string, start int, end int, instance_uuid string) ([]Metric, error) {
+    var metrics []Metric
+
+    err := idb.Each(app_uuid, start, end, 0, instance_uuid, func(m Metric) error {
+        metrics = append(metrics, m)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return metrics, nil
+}
+
+// Each reads the points in chunks, so that they can be streamed without being
+// held in memory. They are the samples themselves, whatever step.
+func (idb *InfluxDB) Each(app_uuid string, start int, end int, step int, instance_uuid string, fn func(Metric) error) error {
+    q := "SELECT cpu, mem, mem_pct, disk, instance_uuid FROM metrics WHERE " + influx.Where(app_uuid, start, end, instance_uuid)
+    err := idb.client.QueryEach(q, func(s influx.Series) error {
+        for _, row := range s.Values {
+            v := influx.Columns(s.Columns, row)
+            err := fn(Metric{
+                Instance_uuid: influx.StringOf(v["instance_uuid"]),
+                Created_at: int(influx.FloatOf(v["time"])),
+                Cpu: influx.FloatOf(v["cpu"]),
+                Mem: influx.FloatOf(v["mem"]),
+                Mem_pct: influx.FloatOf(v["mem_pct"]),
+                Disk: influx.FloatOf(v["disk"])})
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        log.Println("Error occurs when querying against InfluxDB: ", err)
+    }
+    return err
+}
+
+// GetSeries groups by time buckets aligned on sq.Start, InfluxDB fills the empty buckets with null.
+func (idb *InfluxDB) GetSeries(sq SeriesQuery) ([]Series, error) {
+    m, num, err := sq.Check()
+    if err != nil {
+        return nil, err
+    }
+
+    field, measurement, name := "value", "named_metrics", m.Name
+    if m.Source == registry.SourceCollector {
+        field, measurement, name = m.Name, "metrics", ""
+    }
+    q := influx.Grouped(measurement, field, name, sq.Agg, sq.App_uuid, sq.Start, sq.End, sq.Step, sq.By_instance)
+
+    series, err := idb.client.Query(q)
+    if err != nil {
+        log.Println("Error occurs when querying against InfluxDB: ", err)
+        return nil, err
+    }
+
+    values := influx.Buckets(series, sq.Start, sq.Step, num)
+    return MakeSeries(sq, num, values), nil
+}
+
+func (idb *InfluxDB) AddCustom(app_uuid string, metrics []CustomMetric) error {
+    var lines bytes.Buffer
+    for _, m := range metrics {
+        lines.WriteString(influx.Line("named_metrics", app_uuid, m.Instance_uuid, m.Name, influx.Field("value", m.Value), m.Created_at))
+    }
+
+    err := idb.client.Write(lines.Bytes())
+    if err != nil {
+        log.Println("Error occurs when writing custom metric to InfluxDB: ", err)
+        return err
+    }
+    return nil
+}
+
+func (idb *InfluxDB) GetCustom(app_uuid string, name string, start int, end int, instance_uuid string) ([]CustomMetric, error) {
+    var metrics []CustomMetric
+
+    err := idb.EachCustom(app_uuid, name, start, end, instance_uuid, func(m CustomMetric) error {
+        metrics = append(metrics, m)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return metrics, nil
+}
+
+func (idb *InfluxDB) EachCustom(app_uuid string, name string, start int, end int, instance_uuid string, fn func(CustomMetric) error) error {
+    q := "SELECT value, instance_uuid FROM named_metrics WHERE " + influx.Where(app_uuid, start, end, instance_uuid) + " AND name = " + influx.Quote(name)
+    err := idb.client.QueryEach(q, func(s influx.Series) error {
+        for _, row := range s.Values {
+            v := influx.Columns(s.Columns, row)
+            err := fn(CustomMetric{
+                Instance_uuid: influx.StringOf(v["instance_uuid"]),
+                Name: name,
+                Value: influx.FloatOf(v["value"]),
+                Created_at: int(influx.FloatOf(v["time"]))})
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        log.Println("Error occurs when querying against InfluxDB: ", err)
+    }
+    return err
+}
+
+// NewMetricStore returns the store selected by backend: "mysql" (default) or "influxdb".
+func NewMetricStore(backend string, mdb *MetricDB, c map[string]string) (MetricStore, error) {
+    selected, err := influx.Selected(backend, c)
+    if err != nil {
+        return nil, err
+    }
+    if selected {
+        return NewInfluxDB(c), nil
+    }
+    return mdb, nil
+}
@@ -4,17 +4,22 @@ import(
     "fmt"
     "os"
     "flag"
+    "crypto/rand"
+    "encoding/hex"
     "encoding/json"
     "database/sql"
     "net/http"
     "log"
     "strconv"
+    "strings"
     "time"
 
     "github.com/gorilla/mux"
     // tuna
     "github.com/robfig/cron"
     // end tuna
+
+    "registry"
 )
 
 const (
@@ -23,13 +28,16 @@ const (
     ErrMisssingParam = `{"error": "Missing parameter"}`
     ErrExisting = `{"error": "Existing"}`
     ErrNotExist = `{"error": "Not exist"}`
+    ErrUnauthorized = `{"error": "Unauthorized"}`
     SuccessMsg = `{"message": "Successfully"}`
 )
 
 type API struct {
-    mdb *MetricDB 
+    mdb MetricStore
     pdb *PolicyDB
     hdb *HistoryDB
+    ac *AvgerClient
+    fdb *ForecastDB
 }
 
 type Configuration struct {
@@ -38,6 +46,13 @@ type Configuration struct {
     HistoryDB map[string]string
     Logfile string
     Port string
+    AvgerHost string
+    AvgerPort string
+    Metrics string // path to the metric registry file
+    RawRetention int // seconds, as configured for the retention service
+    MinuteRetention int // seconds, as configured for the retention service
+    Backend string // where samples are kept: "mysql" (default, MetricDB) or "influxdb"
+    InfluxDB map[string]string // Url, Database, Username, Password
 }
 
 var api API
@@ -60,6 +75,14 @@ func init() {
         os.Exit(1)
     }
 
+    if cfg.Metrics != "" {
+        err = registry.Load(cfg.Metrics)
+        if err != nil {
+            fmt.Println("Cannot load the metric registry: ", err)
+            os.Exit(1)
+        }
+    }
+
     // PolicyDB connection
     pdb_dsn :=  cfg.PolicyDB["Username"]+":"+
                 cfg.PolicyDB["Password"]+"@tcp("+
@@ -99,13 +122,22 @@ func init() {
     // defer hdb_conn.Close()
 
     pdb := PolicyDB{db: pdb_conn}
-    mdb := MetricDB{db: mdb_conn}
+    mdb := MetricDB{db: mdb_conn, raw_retention: cfg.RawRetention, minute_retention: cfg.MinuteRetention}
     hdb := HistoryDB{db: hdb_conn}
 
+    store, err := NewMetricStore(cfg.Backend, &mdb, cfg.InfluxDB)
+    if err != nil {
+        log.Fatal("Cannot set up the metric backend:", err)
+    }
+
+    ac := AvgerClient{addr: cfg.AvgerHost + ":" + cfg.AvgerPort}
+
     api = API {
         pdb: &pdb,
-        mdb: &mdb,
-        hdb: &hdb}
+        mdb: store,
+        hdb: &hdb,
+        ac: &ac,
+        fdb: &ForecastDB{db: mdb_conn}}
 
 }
 
@@ -124,6 +156,28 @@ func main() {
     r.HandleFunc("/apps/{app_uuid}/metric", GetMetricHandler).Methods("GET")
     r.HandleFunc("/apps/{app_uuid}/metric/avg", GetAvgMetricHandler).Methods("GET")
 
+    // breach state of the policies, kept by the director
+    r.HandleFunc("/apps/{app_uuid}/breaches", GetBreachesHandler).Methods("GET")
+
+    // forecasts of predictive policies, made by the forecaster
+    r.HandleFunc("/apps/{app_uuid}/forecasts", GetForecastsHandler).Methods("GET")
+
+    // backtest api
+    r.HandleFunc("/apps/{app_uuid}/backtest", BacktestHandler).Methods("POST")
+
+    // metric registry api
+    r.HandleFunc("/metrics", ListMetricsHandler).Methods("GET")
+
+    // Grafana SimpleJSON datasource
+    r.HandleFunc("/grafana/", GrafanaTestHandler).Methods("GET")
+    r.HandleFunc("/grafana/search", GrafanaSearchHandler).Methods("POST")
+    r.HandleFunc("/grafana/query", GrafanaQueryHandler).Methods("POST")
+    r.HandleFunc("/grafana/annotations", GrafanaAnnotationsHandler).Methods("POST")
+
+    // custom metric api
+    r.HandleFunc("/apps/{app_uuid}/credential", PostCredentialHandler).Methods("POST")
+    r.HandleFunc("/apps/{app_uuid}/custom_metrics", PostCustomMetricsHandler).Methods("POST")
+
     // tuna
     // policy api
     r.HandleFunc("/policies/{app_uuid}", ListPoliciesHandler).Methods("GET")
@@ -139,6 +193,19 @@ func main() {
     r.HandleFunc("/crons/{app_uuid}/{cron_uuid}", GetCronHandler).Methods("GET")
 
     // end tuna
+
+    // scrape api
+    r.HandleFunc("/scrapes/{app_uuid}", ListScrapesHandler).Methods("GET")
+    r.HandleFunc("/scrapes/{app_uuid}", PostScrapeHandler).Methods("POST")
+    r.HandleFunc("/scrapes/{app_uuid}/{scrape_uuid}", PutScrapeHandler).Methods("PUT")
+    r.HandleFunc("/scrapes/{app_uuid}/{scrape_uuid}", GetScrapeHandler).Methods("GET")
+
+    // queue api
+    r.HandleFunc("/queues/{app_uuid}", ListQueuesHandler).Methods("GET")
+    r.HandleFunc("/queues/{app_uuid}", PostQueueHandler).Methods("POST")
+    r.HandleFunc("/queues/{app_uuid}/{queue_uuid}", PutQueueHandler).Methods("PUT")
+    r.HandleFunc("/queues/{app_uuid}/{queue_uuid}", GetQueueHandler).Methods("GET")
+
     http.Handle("/", r)
 
     err := http.ListenAndServe(":" + cfg.Port, nil)
@@ -276,6 +343,28 @@ func GetAppHandler(w http.ResponseWriter, r *http.Request) {
 
 //tuna
 
+func GetBreachesHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    app_uuid := vars["app_uuid"]
+
+    breaches, err := api.pdb.GetBreaches(app_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    result, err := json.Marshal(breaches)
+    if err != nil {
+        log.Println("Error occurs when marshaling: ", err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(result)
+}
+
 func ListPoliciesHandler(w http.ResponseWriter, r *http.Request) {
     // var policy_ids []int
     var policies []Policy
@@ -654,6 +743,306 @@ func GetCronHandler(w http.ResponseWriter, r *http.Request) {
     w.Write(cron_json)
 }
 // end tuna
+
+// scrape configs
+
+func ListScrapesHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    app_uuid := vars["app_uuid"]
+
+    scrapes, err := api.pdb.GetScrapes(app_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    scrape_json, err := json.Marshal(scrapes)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Write(scrape_json)
+}
+
+func PostScrapeHandler(w http.ResponseWriter, r *http.Request) {
+    var scrape Scrape
+    var exist bool
+    var err error
+
+    err = json.NewDecoder(r.Body).Decode(&scrape)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+    vars := mux.Vars(r)
+    scrape.App_uuid = vars["app_uuid"]
+
+    // check existing app
+    exist, err = api.pdb.IsExistApp(scrape.App_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if exist == false {
+        http.Error(w, ErrNotExist, http.StatusBadRequest)
+        return
+    }
+
+    // check existing scrape config
+    exist, err = api.pdb.IsExistScrape(scrape.Scrape_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if exist {
+        http.Error(w, ErrExisting, http.StatusBadRequest)
+        return
+    }
+
+    // add scrape config
+    err = api.pdb.AddScrape(scrape)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    fmt.Fprint(w, SuccessMsg, http.StatusCreated)
+}
+
+func PutScrapeHandler(w http.ResponseWriter, r *http.Request) {
+    var scrape Scrape
+    var exist bool
+    var err error
+
+    vars := mux.Vars(r)
+    scrape.App_uuid = vars["app_uuid"]
+    scrape.Scrape_uuid = vars["scrape_uuid"]
+
+    // check existing scrape config
+    exist, err = api.pdb.IsExistScrape(scrape.Scrape_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if exist == false {
+        http.Error(w, ErrNotExist, http.StatusBadRequest)
+        return
+    }
+
+    // decode json parameters
+    err = json.NewDecoder(r.Body).Decode(&scrape)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+    scrape.Scrape_uuid = vars["scrape_uuid"]
+
+    // update scrape config
+    err = api.pdb.UpdateScrape(scrape)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    fmt.Fprint(w, SuccessMsg, http.StatusOK)
+}
+
+func GetScrapeHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    scrape_uuid := vars["scrape_uuid"]
+
+    // check existing scrape config
+    exist, err := api.pdb.IsExistScrape(scrape_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if exist == false {
+        http.Error(w, ErrNotExist, http.StatusBadRequest)
+        return
+    }
+
+    scrape, err := api.pdb.GetScrape(scrape_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    scrape_json, err := json.Marshal(scrape)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Write(scrape_json)
+}
+
+// rabbitmq queues
+
+func ListQueuesHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    app_uuid := vars["app_uuid"]
+
+    queues, err := api.pdb.GetQueues(app_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    queue_json, err := json.Marshal(queues)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Write(queue_json)
+}
+
+func PostQueueHandler(w http.ResponseWriter, r *http.Request) {
+    var queue Queue
+    var exist bool
+    var err error
+
+    err = json.NewDecoder(r.Body).Decode(&queue)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+    vars := mux.Vars(r)
+    queue.App_uuid = vars["app_uuid"]
+
+    // check existing app
+    exist, err = api.pdb.IsExistApp(queue.App_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if exist == false {
+        http.Error(w, ErrNotExist, http.StatusBadRequest)
+        return
+    }
+
+    // check existing queue
+    exist, err = api.pdb.IsExistQueue(queue.Queue_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if exist {
+        http.Error(w, ErrExisting, http.StatusBadRequest)
+        return
+    }
+
+    // add queue
+    err = api.pdb.AddQueue(queue)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    fmt.Fprint(w, SuccessMsg, http.StatusCreated)
+}
+
+func PutQueueHandler(w http.ResponseWriter, r *http.Request) {
+    var queue Queue
+    var exist bool
+    var err error
+
+    vars := mux.Vars(r)
+
+    // check existing queue
+    exist, err = api.pdb.IsExistQueue(vars["queue_uuid"])
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if exist == false {
+        http.Error(w, ErrNotExist, http.StatusBadRequest)
+        return
+    }
+
+    // decode json parameters
+    err = json.NewDecoder(r.Body).Decode(&queue)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+    queue.App_uuid = vars["app_uuid"]
+    queue.Queue_uuid = vars["queue_uuid"]
+
+    // update queue
+    err = api.pdb.UpdateQueue(queue)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    fmt.Fprint(w, SuccessMsg, http.StatusOK)
+}
+
+func GetQueueHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    queue_uuid := vars["queue_uuid"]
+
+    // check existing queue
+    exist, err := api.pdb.IsExistQueue(queue_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if exist == false {
+        http.Error(w, ErrNotExist, http.StatusBadRequest)
+        return
+    }
+
+    queue, err := api.pdb.GetQueue(queue_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    queue_json, err := json.Marshal(queue)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Write(queue_json)
+}
+
 // histories 
 
 func GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
@@ -680,9 +1069,42 @@ func GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
         }        
     }
 
-    histories, err := api.hdb.Get(app_uuid, start, end)
+    // real and dry-run decisions side by side by default
+    keep := func(m Metadata) bool { return true }
+    if i, ok := r.Form["mode"]; ok {
+        switch i[0] {
+            case "real":
+                keep = func(m Metadata) bool { return m.DryRun == false }
+            case "dry_run":
+                keep = func(m Metadata) bool { return m.DryRun }
+            case "all":
+            default:
+                http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+                return
+        }
+    }
+
+    if format := Negotiate(r); format != FormatJSON {
+        rw := NewRowWriter(w, format, MetadataHeader)
+        err := api.hdb.Each(app_uuid, start, end, func(m Metadata) error {
+            if keep(m) == false {
+                return nil
+            }
+            return rw.WriteRow(m)
+        })
+        rw.Close(err)
+        return
+    }
+
+    histories := []Metadata{}
+    err = api.hdb.Each(app_uuid, start, end, func(m Metadata) error {
+        if keep(m) {
+            histories = append(histories, m)
+        }
+        return nil
+    })
     if err != nil {
-        log.Fatal("Error occurs when getting history: ", err)
+        log.Println("Error occurs when getting history: ", err)
         http.Error(w, ErrServerFailed, http.StatusInternalServerError)
         return
     }
@@ -726,7 +1148,38 @@ func GetMetricHandler(w http.ResponseWriter, r *http.Request) {
         instance_uuid = i[0]
     }
 
-    metrics, err := api.mdb.Get(app_uuid, start, end, instance_uuid)
+    var name string
+    if i, ok := r.Form["metric"]; ok { // any registered metric but cpu and mem
+        if IsNamedMetric(i[0]) == false {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        name = i[0]
+    }
+
+    if format := Negotiate(r); format != FormatJSON {
+        var rw *RowWriter
+        if name != "" {
+            rw = NewRowWriter(w, format, CustomMetricHeader)
+            err = api.mdb.EachCustom(app_uuid, name, start, end, instance_uuid, func(m CustomMetric) error {
+                return rw.WriteRow(m)
+            })
+        } else {
+            rw = NewRowWriter(w, format, MetricHeader)
+            err = api.mdb.Each(app_uuid, start, end, 0, instance_uuid, func(m Metric) error {
+                return rw.WriteRow(m)
+            })
+        }
+        rw.Close(err)
+        return
+    }
+
+    var metrics interface{}
+    if name != "" {
+        metrics, err = api.mdb.GetCustom(app_uuid, name, start, end, instance_uuid)
+    } else {
+        metrics, err = api.mdb.Get(app_uuid, start, end, instance_uuid)
+    }
     if err != nil {
         log.Fatal("Error occurs when getting metric: ", err)
         http.Error(w, ErrServerFailed, http.StatusInternalServerError)
@@ -767,10 +1220,51 @@ func GetAvgMetricHandler(w http.ResponseWriter, r *http.Request) {
         }        
     }
 
-    var step int = 60 // seconds
-    metrics, err := api.mdb.GetAvg(app_uuid, start, end, step)
+    sq := SeriesQuery{App_uuid: app_uuid, Metric: "cpu", Agg: registry.AggAvg, Start: start, End: end, Step: 60}
+    if _, ok := r.Form["start"]; ok == false { // default: the last hour
+        sq.Start = end - 3600
+    }
+
+    if i, ok := r.Form["step"]; ok { // seconds
+        sq.Step, err = strconv.Atoi(i[0])
+        if err != nil || sq.Step <= 0 {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+    }
+
+    if i, ok := r.Form["metric"]; ok {
+        if IsPolicyMetricName(i[0]) == false {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        sq.Metric = i[0]
+    }
+
+    if i, ok := r.Form["agg"]; ok { // avg, min, max or p95
+        if registry.IsValidAggregation(i[0]) == false || i[0] == registry.AggLast {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        sq.Agg = i[0]
+    }
+
+    if i, ok := r.Form["group_by"]; ok {
+        if i[0] != "instance" {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        sq.By_instance = true
+    }
+
+    if sq.End <= sq.Start || (sq.End - sq.Start) / sq.Step >= MaxPoints {
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    metrics, err := api.mdb.GetSeries(sq)
     if err != nil {
-        log.Fatal("Error occurs when getting metric: ", err)
+        log.Println("Error occurs when getting metric: ", err)
         http.Error(w, ErrServerFailed, http.StatusInternalServerError)
         return
     }
@@ -783,4 +1277,136 @@ func GetAvgMetricHandler(w http.ResponseWriter, r *http.Request) {
     }
 
     w.Write(result)
-}
\ No newline at end of file
+}
+
+// ListMetricsHandler lists the registered metrics policies can refer to.
+func ListMetricsHandler(w http.ResponseWriter, r *http.Request) {
+    result, err := json.Marshal(registry.All())
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.Write(result)
+}
+
+// custom metrics
+
+type CustomMetricsRequest struct {
+    Instance_uuid string // default instance of the metrics
+    Metrics []CustomMetric
+}
+
+// PostCredentialHandler issues a new token for the app to push its custom metrics.
+// The previous token of the app stops working.
+func PostCredentialHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    app_uuid := vars["app_uuid"]
+
+    exist, err := api.pdb.IsExistApp(app_uuid)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if exist == false {
+        http.Error(w, ErrNotExist, http.StatusBadRequest)
+        return
+    }
+
+    b := make([]byte, 32)
+    _, err = rand.Read(b)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+    token := hex.EncodeToString(b)
+
+    err = api.pdb.SetCredential(app_uuid, token)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    result, err := json.Marshal(map[string]string{"App_uuid": app_uuid, "Token": token})
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    w.Write(result)
+}
+
+// PostCustomMetricsHandler stores metrics pushed by an app and forwards them to the avger.
+// The app authenticates with the header "Authorization: Bearer <token>".
+func PostCustomMetricsHandler(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    app_uuid := vars["app_uuid"]
+
+    token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if token == "" {
+        http.Error(w, ErrUnauthorized, http.StatusUnauthorized)
+        return
+    }
+
+    ok, err := api.pdb.CheckCredential(app_uuid, token)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    if ok == false {
+        http.Error(w, ErrUnauthorized, http.StatusUnauthorized)
+        return
+    }
+
+    var req CustomMetricsRequest
+    err = json.NewDecoder(r.Body).Decode(&req)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+        return
+    }
+
+    if len(req.Metrics) == 0 {
+        http.Error(w, ErrMisssingParam, http.StatusBadRequest)
+        return
+    }
+
+    now := int(time.Now().Unix())
+    for i := range req.Metrics {
+        if IsValidMetricName(req.Metrics[i].Name) == false {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        if req.Metrics[i].Instance_uuid == "" {
+            req.Metrics[i].Instance_uuid = req.Instance_uuid
+        }
+        if strings.ContainsAny(req.Metrics[i].Instance_uuid, " \n") {
+            http.Error(w, ErrInvalidParam, http.StatusBadRequest)
+            return
+        }
+        req.Metrics[i].Created_at = now
+    }
+
+    err = api.mdb.AddCustom(app_uuid, req.Metrics)
+    if err != nil {
+        log.Println(err)
+        http.Error(w, ErrServerFailed, http.StatusInternalServerError)
+        return
+    }
+
+    err = api.ac.SendCustom(app_uuid, req.Metrics)
+    if err != nil { // The metrics are stored, the avger will miss them only
+        log.Println("Error occurs when forwarding custom metrics to the avger:", err)
+    }
+
+    fmt.Fprint(w, SuccessMsg, http.StatusCreated)
+}
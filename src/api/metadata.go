@@ -7,6 +7,13 @@ type Metadata struct {
     Threshold float64
     Status int // 1 - Success, 0 - Failed
     InstancesOut int // number of instances be scaled
+    Percent int // percent of instances be scaled, 0 when the policy adjusts by a number of instances
+    NumBefore int // number of running instances before scaling
     NumAfter int // number of running instances after scaling
     CreatedAt int // Unix timestamp
+    Resolution string // why the policy won over the others, e.g. "largest of 2 scale out, 1 scale in overruled"
+    Forecast float64 // forecast of the metric of a predictive policy when the decision was made on it, rather than on the observed value
+    Flapping bool // event of flapping detected, Scale is empty and scaling in is held
+    Reversals int // changes of direction which made the app flap
+    DryRun bool // decision of an app in dry-run mode, the app wasn't scaled
 }
\ No newline at end of file
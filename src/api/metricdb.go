@@ -3,80 +3,479 @@ package main
 import (
     _ "github.com/go-sql-driver/mysql"
     "database/sql"
+    "errors"
     "log"
+    "sort"
+    "strings"
+    "time"
+
+    "registry"
+    "scaling"
 ) 
 
 type Metric struct {
     Instance_uuid string
     Created_at    int
     Cpu           float64
-    Mem           float64
+    Mem           float64 // bytes
+    Mem_pct       float64 // percentage of the memory quota
+    Disk          float64
+}
+
+// CustomMetric is a sample of a metric pushed by the application itself
+type CustomMetric struct {
+    Instance_uuid string
+    Name          string
+    Value         float64
+    Created_at    int
 }
 
 type MetricDB struct {
     db *sql.DB
+    raw_retention int // seconds, raw rows older than that are purged by the retention service
+    minute_retention int // seconds, same for metrics_1m
+}
+
+// Resolution is a table of metrics and how to read its columns.
+type Resolution struct {
+    table string
+    time_col string
+    width int // seconds, 0 for raw samples
+}
+
+// Column returns the expression of the average of column c in a row.
+func (r Resolution) Column(c string) string {
+    if r.width == 0 {
+        return c
+    }
+    return c + "_avg"
+}
+
+// Min returns the expression of the minimum of column c in a row.
+func (r Resolution) Min(c string) string {
+    if r.width == 0 {
+        return c
+    }
+    return c + "_min"
+}
+
+// Max returns the expression of the maximum of column c in a row.
+func (r Resolution) Max(c string) string {
+    if r.width == 0 {
+        return c
+    }
+    return c + "_max"
+}
+
+// Avg returns the expression of the average of column c over grouped rows,
+// weighted by the number of samples behind each rollup row.
+func (r Resolution) Avg(c string) string {
+    if r.width == 0 {
+        return "AVG(" + c + ")"
+    }
+    return "SUM(" + c + "_avg * count) / SUM(IF(" + c + "_avg IS NULL, 0, count))"
+}
+
+// Resolution picks the finest table which still holds the range [start, end)
+// without returning too many rows: raw samples up to 6 hours, 1-minute
+// rollups up to 7 days and 1-hour rollups beyond. step, if not 0, is the
+// width of the buckets the caller groups rows into; a coarser table is
+// fine as long as it isn't wider than step.
+func (mdb *MetricDB) Resolution(start int, end int, step int) Resolution {
+    now := int(time.Now().Unix())
+    span := end - start
+
+    raw := Resolution{table: "metrics", time_col: "created_at", width: 0}
+    minute := Resolution{table: "metrics_1m", time_col: "bucket", width: 60}
+    hour := Resolution{table: "metrics_1h", time_col: "bucket", width: 3600}
+
+    if (mdb.raw_retention <= 0 || start >= now - mdb.raw_retention) && (span <= 6 * 3600 || step > 0 && step < 60) {
+        return raw
+    }
+    if (mdb.minute_retention <= 0 || start >= now - mdb.minute_retention) && (span <= 7 * 24 * 3600 || step > 0 && step < 3600) {
+        return minute
+    }
+    return hour
+}
+
+// Segment is the part [start, end) of a range read from a table.
+type Segment struct {
+    Resolution
+    start int
+    end int
+}
+
+// Segments splits the range [start, end) between the table Resolution picks
+// and finer ones: a rollup table only holds the buckets before the time it is
+// rolled until, the rest of the range is read from the next finer table, and
+// from raw samples when the retention service hasn't rolled anything up. When
+// step isn't 0, the splits fall on its buckets from start, so that no bucket
+// is read from two tables.
+func (mdb *MetricDB) Segments(start int, end int, step int) []Segment {
+    var segments []Segment
+    r := mdb.Resolution(start, end, step)
+    for r.width > 0 && start < end {
+        until, err := mdb.RolledUntil(r.table)
+        if err != nil {
+            log.Println("Error occurs when reading the rollup state of", r.table, ":", err)
+            until = 0
+        }
+        split := end
+        if until < end {
+            split = until
+            if step > 0 && split > start {
+                split = start + (split - start) / step * step
+            }
+        }
+        if split > start {
+            segments = append(segments, Segment{Resolution: r, start: start, end: split})
+            start = split
+        }
+        r = r.Finer()
+    }
+    if start < end {
+        segments = append(segments, Segment{Resolution: r, start: start, end: end})
+    }
+    return segments
+}
+
+// Finer returns the resolution of the next finer table, raw samples being the finest.
+func (r Resolution) Finer() Resolution {
+    if r.width > 60 {
+        return Resolution{table: "metrics_1m", time_col: "bucket", width: 60}
+    }
+    return Resolution{table: "metrics", time_col: "created_at", width: 0}
+}
+
+// RolledUntil returns the time before which the buckets of the rollup table
+// are complete, as the retention service keeps it, 0 if it never ran.
+func (mdb *MetricDB) RolledUntil(table string) (int, error) {
+    var until int
+    err := mdb.db.QueryRow("SELECT rolled_until FROM rollups WHERE table_name = ?", table).Scan(&until)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    }
+    return until, err
 }
 
 func (mdb *MetricDB) Get(app_uuid string, start int, end int, instance_uuid string) ([]Metric, error){
     var metrics []Metric
 
-    q := "SELECT instance_uuid, cpu, mem, created_at FROM metrics WHERE app_uuid = ? AND created_at > ? AND created_at < ?"
+    err := mdb.Each(app_uuid, start, end, 0, instance_uuid, func(m Metric) error {
+        metrics = append(metrics, m)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return metrics, nil
+}
+
+// Each calls fn with the metrics one row at a time, so that they can be
+// streamed without being held in memory. It stops at the first error of fn.
+// The rows are those of the tables Segments picks for the range and step.
+func (mdb *MetricDB) Each(app_uuid string, start int, end int, step int, instance_uuid string, fn func(Metric) error) error {
+    after := " > ?" // start itself is left out
+    for _, seg := range mdb.Segments(start, end, step) {
+        err := mdb.eachRow(seg, after, app_uuid, instance_uuid, fn)
+        if err != nil {
+            return err
+        }
+        after = " >= ?"
+    }
+    return nil
+}
+
+func (mdb *MetricDB) eachRow(seg Segment, after string, app_uuid string, instance_uuid string, fn func(Metric) error) error {
+    r := seg.Resolution
+    q := "SELECT instance_uuid, IFNULL(" + r.Column("cpu") + ", 0), IFNULL(" + r.Column("mem") + ", 0), IFNULL(" + r.Column("mem_pct") + ", 0), IFNULL(" + r.Column("disk") + ", 0), " + r.time_col + " FROM " + r.table + " WHERE app_uuid = ? AND " + r.time_col + after + " AND " + r.time_col + " < ?"
+    args := []interface{}{app_uuid, seg.start, seg.end}
 
     if instance_uuid != "" {
-        q = q + " AND instance_uuid = " + instance_uuid
+        q = q + " AND instance_uuid = ?"
+        args = append(args, instance_uuid)
     }
 
-    q = q + " ORDER BY created_at"
+    q = q + " ORDER BY " + r.time_col
     
-    rows, err := mdb.db.Query(q, app_uuid, start, end)
+    rows, err := mdb.db.Query(q, args...)
     if err != nil {
         log.Println("Error occurs when querying against metric database: ", err)
-        return nil, err
+        return err
     }
     defer rows.Close()
 
     for rows.Next() {
         var m Metric
-        err := rows.Scan(&m.Instance_uuid, &m.Cpu, &m.Mem, &m.Created_at)
+        err := rows.Scan(&m.Instance_uuid, &m.Cpu, &m.Mem, &m.Mem_pct, &m.Disk, &m.Created_at)
         if err != nil {
             log.Println("Error occurs when parsing row: ", err)
+            return err
+        }
+        if err := fn(m); err != nil {
+            return err
+        }
+    }
+    if err := rows.Err(); err != nil {
+        log.Println("Error occurs when parsing rows: ", err)
+        return err
+    }
+
+    return nil
+}
+
+// MaxPoints bounds the number of buckets of a time series
+const MaxPoints = 11000
+
+// Point is a bucket of a time series, Value is null when the bucket has no sample.
+type Point struct {
+    Time  int // start of the bucket
+    Value *float64
+}
+
+// Series is the time series of an app, or of one of its instances when grouped by instance.
+type Series struct {
+    Instance_uuid string
+    Points        []Point
+}
+
+// SeriesQuery asks for the metric of an app in buckets of Step seconds over [Start, End).
+type SeriesQuery struct {
+    App_uuid string
+    Metric string
+    Agg string // an aggregation of the registry but last, which the buckets are not reduced with
+    Start int
+    End int
+    Step int
+    By_instance bool
+}
+
+// Check validates the query, and returns its metric and number of buckets.
+func (sq SeriesQuery) Check() (registry.Metric, int, error) {
+    m, ok := registry.Lookup(sq.Metric)
+    if ok == false || registry.IsValidAggregation(sq.Agg) == false || sq.Agg == registry.AggLast || sq.Step <= 0 || sq.End <= sq.Start {
+        return m, 0, errors.New("Invalid series query")
+    }
+    num := (sq.End - sq.Start + sq.Step - 1) / sq.Step
+    if num > MaxPoints {
+        return m, 0, errors.New("Too many points")
+    }
+    return m, num, nil
+}
+
+// GetSeries computes the time series with a grouped query per table.
+// Collector metrics come from the metrics table or its rollups, depending on
+// the range, see Segments; the p95 of rollups is computed over the averages of
+// their rows. Other metrics come from named_metrics.
+func (mdb *MetricDB) GetSeries(sq SeriesQuery) ([]Series, error) {
+    m, num, err := sq.Check()
+    if err != nil {
+        return nil, err
+    }
+
+    values := make(map[string]map[int][]float64) // instance_uuid -> bucket -> values
+    if m.Source != registry.SourceCollector {
+        var value string
+        switch sq.Agg {
+            case registry.AggAvg, registry.AggMin, registry.AggMax:
+                value = strings.ToUpper(sq.Agg) + "(value)"
+            case registry.AggP95:
+                value = "value"
+        }
+        err := mdb.groupRows(sq, "named_metrics", "created_at", value, sq.Start, sq.End, values)
+        if err != nil {
             return nil, err
         }
-        metrics = append(metrics, m)
+        return MakeSeries(sq, num, values), nil
+    }
+
+    for _, seg := range mdb.Segments(sq.Start, sq.End, sq.Step) {
+        var value string
+        switch sq.Agg {
+            case registry.AggAvg:
+                value = seg.Avg(m.Name)
+            case registry.AggMin:
+                value = "MIN(" + seg.Min(m.Name) + ")"
+            case registry.AggMax:
+                value = "MAX(" + seg.Max(m.Name) + ")"
+            case registry.AggP95:
+                value = seg.Column(m.Name)
+        }
+        err := mdb.groupRows(sq, seg.table, seg.time_col, value, seg.start, seg.end, values)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return MakeSeries(sq, num, values), nil
+}
+
+// groupRows adds the values of the metric of sq in the rows of table over
+// [start, end) to values, by instance and bucket of sq.
+func (mdb *MetricDB) groupRows(sq SeriesQuery, table string, time_col string, value string, start int, end int, values map[string]map[int][]float64) error {
+    group := ""
+    instance := "''"
+    if sq.By_instance {
+        instance = "instance_uuid"
+        group = ", instance_uuid"
+    }
+    bucket := "FLOOR((" + time_col + " - ?) / ?)"
+    args := []interface{}{sq.Start, sq.Step, sq.App_uuid, start, end}
+
+    q := "SELECT " + bucket + " AS b, " + instance + ", " + value + " FROM " + table +
+        " WHERE app_uuid = ? AND " + time_col + " >= ? AND " + time_col + " < ?"
+    if table == "named_metrics" {
+        q = q + " AND name = ?"
+        args = append(args, sq.Metric)
+    }
+    if sq.Agg == registry.AggP95 {
+        q = q + " AND " + value + " IS NOT NULL ORDER BY b" + group
+    } else {
+        q = q + " GROUP BY b" + group + " ORDER BY b"
+    }
+
+    rows, err := mdb.db.Query(q, args...)
+    if err != nil {
+        log.Println("Error occurs when querying against metric database: ", err)
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var b int
+        var instance_uuid string
+        var v sql.NullFloat64
+        err := rows.Scan(&b, &instance_uuid, &v)
+        if err != nil {
+            log.Println("Error occurs when parsing row: ", err)
+            return err
+        }
+        if _, exist := values[instance_uuid]; exist == false {
+            values[instance_uuid] = make(map[int][]float64)
+        }
+        if v.Valid {
+            values[instance_uuid][b] = append(values[instance_uuid][b], v.Float64)
+        }
     }
     if err := rows.Err(); err != nil {
         log.Println("Error occurs when parsing rows: ", err)
+        return err
+    }
+    return nil
+}
+
+// MakeSeries lays the values of each instance (or of "" when not grouped by
+// instance) out in num buckets, null when empty. A bucket of several values
+// is reduced to their p95, otherwise its single value is kept.
+func MakeSeries(sq SeriesQuery, num int, values map[string]map[int][]float64) []Series {
+    var instances []string
+    for instance_uuid := range values {
+        instances = append(instances, instance_uuid)
+    }
+    if sq.By_instance == false && len(instances) == 0 {
+        instances = append(instances, "")
+    }
+    sort.Strings(instances)
+
+    var series []Series
+    for _, instance_uuid := range instances {
+        s := Series{Instance_uuid: instance_uuid, Points: make([]Point, num)}
+        for b := 0; b < num; b++ {
+            s.Points[b].Time = sq.Start + b * sq.Step
+            vs := values[instance_uuid][b]
+            if len(vs) == 0 {
+                continue // null bucket
+            }
+            v := vs[0]
+            if sq.Agg == registry.AggP95 {
+                v = scaling.Percentile(vs, 95)
+            }
+            s.Points[b].Value = &v
+        }
+        series = append(series, s)
+    }
+
+    return series
+}
+
+// IsValidMetricName tells whether apps can push the metric: it must be registered from the app source.
+func IsValidMetricName(name string) bool {
+    m, ok := registry.Lookup(name)
+    return ok && m.Source == registry.SourceApp
+}
+
+// IsPolicyMetricName tells whether a policy can refer to the metric by Metric_name.
+func IsPolicyMetricName(name string) bool {
+    _, ok := registry.Lookup(name)
+    return ok
+}
+
+// IsNamedMetric tells whether the metric is stored in the named_metrics table.
+func IsNamedMetric(name string) bool {
+    m, ok := registry.Lookup(name)
+    return ok && m.Source != registry.SourceCollector
+}
+
+func (mdb *MetricDB) AddCustom(app_uuid string, metrics []CustomMetric) error {
+    for _, m := range metrics {
+        _, err := mdb.db.Exec("INSERT INTO named_metrics(app_uuid, instance_uuid, name, value, created_at) VALUES (?, ?, ?, ?, ?)", app_uuid, m.Instance_uuid, m.Name, m.Value, m.Created_at)
+        if err != nil {
+            log.Println("Error occurs when inserting custom metric: ", err)
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (mdb *MetricDB) GetCustom(app_uuid string, name string, start int, end int, instance_uuid string) ([]CustomMetric, error) {
+    var metrics []CustomMetric
+
+    err := mdb.EachCustom(app_uuid, name, start, end, instance_uuid, func(m CustomMetric) error {
+        metrics = append(metrics, m)
+        return nil
+    })
+    if err != nil {
         return nil, err
     }
 
     return metrics, nil
 }
 
-func (mdb *MetricDB) GetAvg(app_uuid string, start int, end int, step int) ([]Metric, error) {
-    var metrics []Metric
+// EachCustom is Each for the samples of a named metric.
+func (mdb *MetricDB) EachCustom(app_uuid string, name string, start int, end int, instance_uuid string, fn func(CustomMetric) error) error {
+    q := "SELECT instance_uuid, name, value, created_at FROM named_metrics WHERE app_uuid = ? AND name = ? AND created_at > ? AND created_at < ?"
+    args := []interface{}{app_uuid, name, start, end}
+
+    if instance_uuid != "" {
+        q = q + " AND instance_uuid = ?"
+        args = append(args, instance_uuid)
+    }
 
-    q := "SELECT avg(cpu), avg(mem) FROM metrics WHERE app_uuid = ? AND created_at > ? AND created_at < ?"
+    q = q + " ORDER BY created_at"
 
-    tmp_start := start
-    var tmp_metric []sql.NullFloat64
-    var metric Metric 
-    for tmp_start < end {
-        metric.Created_at = tmp_start
-        err := mdb.db.QueryRow(q, app_uuid, tmp_start, end).Scan(&tmp_metric)
+    rows, err := mdb.db.Query(q, args...)
+    if err != nil {
+        log.Println("Error occurs when querying against metric database: ", err)
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var m CustomMetric
+        err := rows.Scan(&m.Instance_uuid, &m.Name, &m.Value, &m.Created_at)
         if err != nil {
-            log.Println("Error occurs when querying metric database:", err)
-            return metrics, err
+            log.Println("Error occurs when parsing row: ", err)
+            return err
         }
-        if tmp_metric[0].Valid {
-            metric.Cpu = tmp_metric[0].Float64
-            metric.Mem = tmp_metric[1].Float64
-        } else { // Null value
-            metric.Cpu = 0
-            metric.Mem = 0
+        if err := fn(m); err != nil {
+            return err
         }
-        metrics = append(metrics, metric)
-        tmp_start = tmp_start + step
+    }
+    if err := rows.Err(); err != nil {
+        log.Println("Error occurs when parsing rows: ", err)
+        return err
     }
 
-    return metrics, nil
-}
\ No newline at end of file
+    return nil
+}
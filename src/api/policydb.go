@@ -1,11 +1,19 @@
 package main
 
 import (
+    "crypto/sha256"
     "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
     "log"
     "errors"
     "strconv"
-    // "time"
+    "strings"
+    "time"
+
+    "registry"
+    "scaling"
  )
     
 type PolicyDB struct {
@@ -17,13 +25,29 @@ type Policy struct {
     Policy_uuid string
     App_uuid string
     // end tuna
-    Metric_type int 
+    Policy_type string // threshold (default), target, step or condition, see scaling.PolicyThreshold
+    Metric_type int // legacy, used when Metric_name is empty
+    Metric_name string // name in the metric registry
+    Per_instance *bool // thresholds are per instance, the metric is divided by the number of instances, left unchanged by updates if null
     Upper_threshold float64
     Lower_threshold float64
     Instances_out int
     Instances_in int
+    Target_value float64 // target policies: value of the metric to keep
+    Tolerance float64 // target policies: fraction of Target_value within which the app isn't scaled
+    Steps []scaling.Step // step policies: bands of thresholds, stored in policy_steps
+    Adjustment_type string // count or percent: Instances_out, Instances_in and steps are percentages of the current instances
+    Min_adjustment int // percent adjustments: least number of instances to add or remove
+    Out_condition *string // condition policies: expression over metrics, e.g. "cpu > 70 and p95(latency) > 500", left unchanged by updates if null, "" clears it
+    In_condition *string // condition policies: e.g. "cpu < 30 and mem_pct < 40"
+    Breach_evaluations int // consecutive evaluations deciding the same scaling before acting
+    Breach_duration int // seconds the policy must have decided the same scaling before acting
     Cooldown_period int
+    Cooldown_out int // seconds scaling out cools down after this policy scaled out, Cooldown_period if 0
+    Cooldown_in int // seconds scaling in cools down after this policy scaled in, Cooldown_period if 0
     Measurement_period int
+    Predictive *bool // threshold, target and step policies: pre-scale on the forecast of the metric when higher than the observed value, left unchanged by updates if null
+    Forecast_lead int // predictive policies: seconds ahead the forecast is taken at, 900 if 0
     // tuna
     Deleted bool
     // end tuna
@@ -35,6 +59,20 @@ type Application struct {
     Min_instances int 
     Max_instances int
     Enabled bool
+    Dry_run *bool // the engine records its decisions to the history without scaling, left unchanged by updates if null
+    // Failure state, kept by the director, read-only
+    Failures int // consecutive failures to scale, the app is retried with an exponential backoff
+    Last_error string
+    Failed_at int
+    At_limit string // out or in: the app is at its bounds, it isn't scaled that way until they change
+    Flapping_until int // unix time until which the app is held from scaling in, as it flapped
+    Flapping bool
+    // Protection against scaling in, scaling out remains allowed. Left
+    // unchanged by updates if null, 0 and "" remove it.
+    Max_in_per_hour *int // instances which may be removed within an hour, unlimited if 0
+    Min_instance_age *int // seconds since the last scale out before scaling in
+    Protection_windows *string // e.g. "mon-fri 09:00-18:00, sat 10:00-14:00", scaling in is blocked within them
+    Protection_timezone *string // of the windows, e.g. "Europe/Paris", UTC by default
 }
 
 // tuna
@@ -49,6 +87,37 @@ type Crontab struct {
 }
 // end tuna
 
+// Scrape tells the scraper where an app exposes its Prometheus metrics
+type Scrape struct {
+    App_uuid string
+    Scrape_uuid string
+    Url string
+    Scrape_interval int // seconds
+    Per_instance bool
+    Mappings []ScrapeMapping
+    Deleted bool
+}
+
+// ScrapeMapping stores the sum of the series matching Series and Labels as the custom metric Name
+type ScrapeMapping struct {
+    Series string
+    Labels map[string]string
+    Name string
+}
+
+// Queue is a RabbitMQ queue whose backlog drives the scaling of the app consuming it
+type Queue struct {
+    App_uuid string
+    Queue_uuid string
+    Api_url string // RabbitMQ management API, e.g. http://rabbitmq.example.com:15672
+    Vhost string
+    Queue string
+    Username string
+    Password string
+    Poll_interval int // seconds
+    Deleted bool
+}
+
 func (pdb *PolicyDB) IsExistApp(app_uuid string) (bool, error) {
     rows, err := pdb.db.Query("SELECT Id FROM apps WHERE app_uuid = ?", app_uuid)
     if err != nil {
@@ -66,19 +135,26 @@ func (pdb *PolicyDB) IsExistApp(app_uuid string) (bool, error) {
 
 func (pdb *PolicyDB) GetApp(app_uuid string) (Application, error) {
     var app Application
+    var dry_run bool
+    var max_in_per_hour, min_instance_age int
+    var protection_windows, protection_timezone string
     log.Println(app_uuid)
-    err := pdb.db.QueryRow("SELECT app_uuid, name, min_instances, max_instances, enabled FROM apps WHERE app_uuid = ?", app_uuid).Scan(&app.App_uuid, &app.Name, &app.Min_instances, &app.Max_instances, &app.Enabled)
+    err := pdb.db.QueryRow("SELECT app_uuid, name, min_instances, max_instances, enabled, dry_run, failures, last_error, failed_at, at_limit, flapping_until, max_in_per_hour, min_instance_age, protection_windows, protection_timezone FROM apps WHERE app_uuid = ?", app_uuid).Scan(&app.App_uuid, &app.Name, &app.Min_instances, &app.Max_instances, &app.Enabled, &dry_run, &app.Failures, &app.Last_error, &app.Failed_at, &app.At_limit, &app.Flapping_until, &max_in_per_hour, &min_instance_age, &protection_windows, &protection_timezone)
     if err != nil {
         log.Println("Error occurs when getting application:", err)
         return app, err
     }
+    app.Dry_run = &dry_run
+    app.Max_in_per_hour, app.Min_instance_age = &max_in_per_hour, &min_instance_age
+    app.Protection_windows, app.Protection_timezone = &protection_windows, &protection_timezone
+    app.Flapping = app.Flapping_until > int(time.Now().Unix())
 
     return app, nil
 }
 // chanhlv
 func (pdb *PolicyDB) GetApps() ([]Application, error) {
     var apps []Application
-    rows, err := pdb.db.Query("SELECT app_uuid, name, min_instances, max_instances, enabled FROM apps")
+    rows, err := pdb.db.Query("SELECT app_uuid, name, min_instances, max_instances, enabled, dry_run, failures, last_error, failed_at, at_limit, flapping_until, max_in_per_hour, min_instance_age, protection_windows, protection_timezone FROM apps")
     if err != nil {
         log.Println("Error occurs when querying database:", err)
     }
@@ -86,10 +162,17 @@ func (pdb *PolicyDB) GetApps() ([]Application, error) {
  
     for rows.Next() {
         var app Application
-        err = rows.Scan(&app.App_uuid, &app.Name, &app.Min_instances, &app.Max_instances, &app.Enabled)
+        var dry_run bool
+        var max_in_per_hour, min_instance_age int
+        var protection_windows, protection_timezone string
+        err = rows.Scan(&app.App_uuid, &app.Name, &app.Min_instances, &app.Max_instances, &app.Enabled, &dry_run, &app.Failures, &app.Last_error, &app.Failed_at, &app.At_limit, &app.Flapping_until, &max_in_per_hour, &min_instance_age, &protection_windows, &protection_timezone)
         if err != nil {
             panic(err.Error())
         }
+        app.Dry_run = &dry_run
+        app.Max_in_per_hour, app.Min_instance_age = &max_in_per_hour, &min_instance_age
+        app.Protection_windows, app.Protection_timezone = &protection_windows, &protection_timezone
+        app.Flapping = app.Flapping_until > int(time.Now().Unix())
         apps = append(apps, app)
     }
     return apps, err
@@ -111,8 +194,25 @@ func (pdb *PolicyDB) AddApp(app Application) error {
     if app.Max_instances == 0 {
         app.Max_instances = 5
     }
+    dry_run := app.Dry_run != nil && *app.Dry_run
+    if err := validateProtection(app); err != nil {
+        return err
+    }
+    max_in_per_hour, min_instance_age, protection_windows, protection_timezone := 0, 0, "", "UTC"
+    if app.Max_in_per_hour != nil {
+        max_in_per_hour = *app.Max_in_per_hour
+    }
+    if app.Min_instance_age != nil {
+        min_instance_age = *app.Min_instance_age
+    }
+    if app.Protection_windows != nil {
+        protection_windows = *app.Protection_windows
+    }
+    if app.Protection_timezone != nil && *app.Protection_timezone != "" {
+        protection_timezone = *app.Protection_timezone
+    }
 
-    _, err := pdb.db.Exec("INSERT INTO apps(app_uuid, name, min_instances, max_instances, enabled) VALUES (?, ?, ?, ?, ?)", app.App_uuid, app.Name, app.Min_instances, app.Max_instances, app.Enabled)
+    _, err := pdb.db.Exec("INSERT INTO apps(app_uuid, name, min_instances, max_instances, enabled, dry_run, max_in_per_hour, min_instance_age, protection_windows, protection_timezone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", app.App_uuid, app.Name, app.Min_instances, app.Max_instances, app.Enabled, dry_run, max_in_per_hour, min_instance_age, protection_windows, protection_timezone)
     if err != nil {
         return err
     }
@@ -121,6 +221,10 @@ func (pdb *PolicyDB) AddApp(app Application) error {
 }
 
 func (pdb *PolicyDB) UpdateApp(app Application) error {
+    if err := validateProtection(app); err != nil {
+        return err
+    }
+
     q := "UPDATE apps SET "
     if app.Name != "" {
         q = q + " name = '" + app.Name + "'" + ", "
@@ -131,6 +235,29 @@ func (pdb *PolicyDB) UpdateApp(app Application) error {
     if app.Max_instances != 0 {
         q = q + "max_instances = " + strconv.Itoa(app.Max_instances) + ", "
     }
+    if app.Min_instances != 0 || app.Max_instances != 0 {
+        // New bounds, the engine can try again the direction it was at the limit of
+        q = q + "next_out_time = IF(at_limit = 'out', 0, next_out_time), next_in_time = IF(at_limit = 'in', 0, next_in_time), at_limit = '', "
+    }
+    if app.Max_in_per_hour != nil {
+        q = q + "max_in_per_hour = " + strconv.Itoa(*app.Max_in_per_hour) + ", "
+    }
+    if app.Min_instance_age != nil {
+        q = q + "min_instance_age = " + strconv.Itoa(*app.Min_instance_age) + ", "
+    }
+    if app.Protection_windows != nil {
+        q = q + "protection_windows = '" + *app.Protection_windows + "', "
+    }
+    if app.Protection_timezone != nil {
+        if *app.Protection_timezone == "" {
+            q = q + "protection_timezone = 'UTC', "
+        } else {
+            q = q + "protection_timezone = '" + *app.Protection_timezone + "', "
+        }
+    }
+    if app.Dry_run != nil { // Left out, a shadow app mustn't turn into a live one
+        q = q + "dry_run = " + strconv.FormatBool(*app.Dry_run) + ", "
+    }
     q = q + "enabled = " + strconv.FormatBool(app.Enabled)
     q = q + " WHERE app_uuid = '" + app.App_uuid + "'"
 
@@ -142,6 +269,42 @@ func (pdb *PolicyDB) UpdateApp(app Application) error {
     return nil
 }
 
+// SetCredential replaces the credential an app uses to push custom metrics.
+// Only the SHA-256 hash of the token is stored.
+func (pdb *PolicyDB) SetCredential(app_uuid string, token string) error {
+    _, err := pdb.db.Exec("DELETE FROM credentials WHERE app_uuid = ?", app_uuid)
+    if err != nil {
+        return err
+    }
+
+    _, err = pdb.db.Exec("INSERT INTO credentials(app_uuid, token_hash, created_at) VALUES (?, ?, ?)", app_uuid, hashToken(token), time.Now().Unix())
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+func (pdb *PolicyDB) CheckCredential(app_uuid string, token string) (bool, error) {
+    rows, err := pdb.db.Query("SELECT Id FROM credentials WHERE app_uuid = ? AND token_hash = ?", app_uuid, hashToken(token))
+    if err != nil {
+        log.Println("Error occurs when querying database:", err)
+        return false, err
+    }
+    defer rows.Close()
+
+    if rows.Next() {
+        return true, nil
+    } else {
+        return false, nil
+    }
+}
+
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
 // tuna
 func (pdb *PolicyDB) IsExistCron(cron_uuid string) (bool, error) {
     rows, err := pdb.db.Query("SELECT Id FROM crons WHERE cron_uuid = ?", cron_uuid)
@@ -242,6 +405,273 @@ func (pdb *PolicyDB) IsExistPolicy(policy_uuid string) (bool, error) {
 }
 
 
+// validatePolicy checks the fields given in a new or updated policy.
+func validatePolicy(policy Policy) error {
+    if scaling.IsValidPolicyType(policy.Policy_type) == false {
+        return errors.New("Policy_type is unknown")
+    }
+    if policy.Target_value < 0 {
+        return errors.New("Target_value must be positive")
+    }
+    if policy.Tolerance < 0 || policy.Tolerance >= 1 {
+        return errors.New("Tolerance must be in [0, 1)")
+    }
+    if policy.Cooldown_out < 0 || policy.Cooldown_in < 0 {
+        return errors.New("Cooldown_out and Cooldown_in must be positive")
+    }
+    if policy.Breach_evaluations < 0 || policy.Breach_duration < 0 {
+        return errors.New("Breach_evaluations and Breach_duration must be positive")
+    }
+    if scaling.IsValidAdjustmentType(policy.Adjustment_type) == false {
+        return errors.New("Adjustment_type is unknown")
+    }
+    if policy.Min_adjustment < 0 {
+        return errors.New("Min_adjustment must be positive")
+    }
+    if policy.Steps != nil {
+        if err := scaling.ValidateSteps(policy.Steps); err != nil {
+            return err
+        }
+    }
+    for _, cond := range []string{condition(policy.Out_condition), condition(policy.In_condition)} {
+        if err := validateCondition(cond); err != nil {
+            return err
+        }
+    }
+    if policy.Predictive != nil && *policy.Predictive && policy.Policy_type == scaling.PolicyCondition {
+        return errors.New("Condition policies can't be predictive")
+    }
+    if policy.Forecast_lead < 0 {
+        return errors.New("Forecast_lead must be positive")
+    }
+    return nil
+}
+
+// validateRequired checks that a new policy, or an updated one merged into
+// the stored one, has the fields its type needs to ever scale.
+func validateRequired(policy Policy) error {
+    if policy.Policy_type == scaling.PolicyTarget && policy.Target_value <= 0 {
+        return errors.New("Target_value is missing")
+    }
+    if policy.Policy_type == scaling.PolicyStep && len(policy.Steps) == 0 {
+        return errors.New("Steps are missing")
+    }
+    if policy.Policy_type == scaling.PolicyCondition && condition(policy.Out_condition) == "" && condition(policy.In_condition) == "" {
+        return errors.New("Out_condition or In_condition is missing")
+    }
+    return nil
+}
+
+// condition returns the condition of a policy, empty if null.
+func condition(cond *string) string {
+    if cond == nil {
+        return ""
+    }
+    return *cond
+}
+
+// mergePolicy returns the stored policy with the fields of an update, as
+// UpdatePolicy writes them.
+func mergePolicy(stored Policy, update Policy) Policy {
+    if update.Policy_type != "" {
+        stored.Policy_type = update.Policy_type
+    }
+    if update.Metric_type != 0 {
+        stored.Metric_type = update.Metric_type
+    }
+    if update.Metric_name != "" {
+        stored.Metric_name = update.Metric_name
+    }
+    if update.Per_instance != nil {
+        stored.Per_instance = update.Per_instance
+    }
+    if update.Upper_threshold != 0 {
+        stored.Upper_threshold = update.Upper_threshold
+    }
+    if update.Lower_threshold != 0 {
+        stored.Lower_threshold = update.Lower_threshold
+    }
+    if update.Instances_out != 0 {
+        stored.Instances_out = update.Instances_out
+    }
+    if update.Instances_in != 0 {
+        stored.Instances_in = update.Instances_in
+    }
+    if update.Target_value != 0 {
+        stored.Target_value = update.Target_value
+    }
+    if update.Tolerance != 0 {
+        stored.Tolerance = update.Tolerance
+    }
+    if update.Steps != nil {
+        stored.Steps = update.Steps
+    }
+    if update.Adjustment_type != "" {
+        stored.Adjustment_type = update.Adjustment_type
+    }
+    if update.Min_adjustment != 0 {
+        stored.Min_adjustment = update.Min_adjustment
+    }
+    if update.Out_condition != nil {
+        stored.Out_condition = update.Out_condition
+    }
+    if update.In_condition != nil {
+        stored.In_condition = update.In_condition
+    }
+    if update.Breach_evaluations != 0 {
+        stored.Breach_evaluations = update.Breach_evaluations
+    }
+    if update.Breach_duration != 0 {
+        stored.Breach_duration = update.Breach_duration
+    }
+    if update.Cooldown_period != 0 {
+        stored.Cooldown_period = update.Cooldown_period
+    }
+    if update.Cooldown_out != 0 {
+        stored.Cooldown_out = update.Cooldown_out
+    }
+    if update.Cooldown_in != 0 {
+        stored.Cooldown_in = update.Cooldown_in
+    }
+    if update.Measurement_period != 0 {
+        stored.Measurement_period = update.Measurement_period
+    }
+    if update.Predictive != nil {
+        stored.Predictive = update.Predictive
+    }
+    if update.Forecast_lead != 0 {
+        stored.Forecast_lead = update.Forecast_lead
+    }
+    stored.Deleted = update.Deleted
+    return stored
+}
+
+// validateProtection checks the protection against scaling in given in a new
+// or updated app, where it isn't null. Windows and timezone are also checked to
+// be safe in queries.
+func validateProtection(app Application) error {
+    if app.Max_in_per_hour != nil && *app.Max_in_per_hour < 0 || app.Min_instance_age != nil && *app.Min_instance_age < 0 {
+        return errors.New("Max_in_per_hour and Min_instance_age must be positive")
+    }
+    if app.Protection_windows != nil {
+        if _, err := scaling.ParseWindows(*app.Protection_windows); err != nil {
+            return err
+        }
+    }
+    if app.Protection_timezone != nil {
+        if strings.ContainsAny(*app.Protection_timezone, "'\\") {
+            return errors.New("Protection_timezone is unknown")
+        }
+        if _, err := time.LoadLocation(*app.Protection_timezone); err != nil {
+            return errors.New("Protection_timezone is unknown")
+        }
+    }
+    return nil
+}
+
+// validateCondition checks the syntax and the metrics of a condition, if any.
+func validateCondition(cond string) error {
+    if cond == "" {
+        return nil
+    }
+
+    c, err := scaling.ParseCondition(cond)
+    if err != nil {
+        return err
+    }
+    for _, cmp := range c.Comparisons() {
+        if IsPolicyMetricName(cmp.Metric) == false {
+            return errors.New("Metric " + cmp.Metric + " of condition is unknown")
+        }
+    }
+    return nil
+}
+
+// setSteps replaces the bands of a step policy.
+func (pdb *PolicyDB) setSteps(policy_uuid string, steps []scaling.Step) error {
+    _, err := pdb.db.Exec("DELETE FROM policy_steps WHERE policy_uuid = ?", policy_uuid)
+    if err != nil {
+        return err
+    }
+
+    for _, step := range steps {
+        _, err := pdb.db.Exec("INSERT INTO policy_steps(policy_uuid, scale_type, threshold, instances) VALUES (?, ?, ?, ?)", policy_uuid, step.Scale, step.Threshold, step.Instances)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (pdb *PolicyDB) getSteps(policy_uuid string) ([]scaling.Step, error) {
+    var steps []scaling.Step
+    rows, err := pdb.db.Query("SELECT scale_type, threshold, instances FROM policy_steps WHERE policy_uuid = ? ORDER BY threshold", policy_uuid)
+    if err != nil {
+        log.Println("Error occurs when getting policy steps:", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var step scaling.Step
+        err := rows.Scan(&step.Scale, &step.Threshold, &step.Instances)
+        if err != nil {
+            return nil, err
+        }
+        steps = append(steps, step)
+    }
+    return steps, rows.Err()
+}
+
+// Breach tells how long a policy has been deciding the same scaling, e.g.
+// "breaching out for 2 of 3 evaluations", as the director keeps it for the engine.
+type Breach struct {
+    Policy_uuid string
+    Scale string // out or in, empty when the policy decided nothing at its last evaluation
+    Evaluations int
+    Breach_evaluations int
+    Since int
+    Breach_duration int
+    Breaching bool // the breach lasted long enough for the policy to scale
+    Status string
+}
+
+func (pdb *PolicyDB) GetBreaches(app_uuid string) ([]Breach, error) {
+    breaches := []Breach{}
+    rows, err := pdb.db.Query("SELECT p.policy_uuid, COALESCE(b.scale_type, ''), COALESCE(b.evaluations, 0), COALESCE(b.since, 0), p.breach_evaluations, p.breach_duration FROM policies p LEFT JOIN breaches b ON b.policy_uuid = p.policy_uuid WHERE p.app_uuid = ? AND p.deleted = false", app_uuid)
+    if err != nil {
+        log.Println("Error occurs when getting breaches:", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    now := int(time.Now().Unix())
+    for rows.Next() {
+        var b Breach
+        err := rows.Scan(&b.Policy_uuid, &b.Scale, &b.Evaluations, &b.Since, &b.Breach_evaluations, &b.Breach_duration)
+        if err != nil {
+            return nil, err
+        }
+
+        required := b.Breach_evaluations
+        if required < 1 {
+            required = 1
+        }
+        if b.Scale == "" {
+            b.Status = "not breaching"
+        } else {
+            state := scaling.Breach{Scale: b.Scale, Evaluations: b.Evaluations, Since: b.Since}
+            b.Breaching = state.Lasted(scaling.Policy{Breach_evaluations: b.Breach_evaluations, Breach_duration: b.Breach_duration}, now)
+            b.Status = fmt.Sprintf("breaching %s for %d of %d evaluations", b.Scale, b.Evaluations, required)
+            if b.Breach_duration > 0 {
+                b.Status = b.Status + fmt.Sprintf(", %d of %d seconds", now - b.Since, b.Breach_duration)
+            }
+        }
+        breaches = append(breaches, b)
+    }
+    return breaches, rows.Err()
+}
+
 func (pdb *PolicyDB) AddPolicy(policy Policy) error {
     if policy.App_uuid == "" {
         return errors.New("App_uuid is missing")
@@ -249,20 +679,72 @@ func (pdb *PolicyDB) AddPolicy(policy Policy) error {
     if policy.Policy_uuid == "" {
         return errors.New("Policy_uuid is missing")
     }
+    if policy.Metric_name == "" {
+        m, ok := registry.ByType(policy.Metric_type)
+        if ok == false {
+            return errors.New("Metric_type is unknown")
+        }
+        policy.Metric_name = m.Name
+    }
+    if IsPolicyMetricName(policy.Metric_name) == false {
+        return errors.New("Metric_name is unknown")
+    }
+    if policy.Policy_type == "" {
+        policy.Policy_type = scaling.PolicyThreshold
+    }
+    if policy.Adjustment_type == "" {
+        policy.Adjustment_type = scaling.AdjustCount
+    }
+    if err := validatePolicy(policy); err != nil {
+        return err
+    }
+    if err := validateRequired(policy); err != nil {
+        return err
+    }
+    per_instance := policy.Per_instance != nil && *policy.Per_instance
+    predictive := policy.Predictive != nil && *policy.Predictive
 
-    _, err := pdb.db.Exec("INSERT INTO policies(app_uuid, policy_uuid, metric_type, upper_threshold, lower_threshold, instances_out, instances_in, cooldown_period, measurement_period, deleted) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", policy.App_uuid, policy.Policy_uuid, policy.Metric_type, policy.Upper_threshold, policy.Lower_threshold, policy.Instances_out, policy.Instances_in, policy.Cooldown_period, policy.Measurement_period, policy.Deleted)
+    _, err := pdb.db.Exec("INSERT INTO policies(app_uuid, policy_uuid, policy_type, metric_type, metric_name, per_instance, upper_threshold, lower_threshold, instances_out, instances_in, target_value, tolerance, adjustment_type, min_adjustment, out_condition, in_condition, breach_evaluations, breach_duration, cooldown_period, cooldown_out, cooldown_in, measurement_period, predictive, forecast_lead, deleted) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", policy.App_uuid, policy.Policy_uuid, policy.Policy_type, policy.Metric_type, policy.Metric_name, per_instance, policy.Upper_threshold, policy.Lower_threshold, policy.Instances_out, policy.Instances_in, policy.Target_value, policy.Tolerance, policy.Adjustment_type, policy.Min_adjustment, condition(policy.Out_condition), condition(policy.In_condition), policy.Breach_evaluations, policy.Breach_duration, policy.Cooldown_period, policy.Cooldown_out, policy.Cooldown_in, policy.Measurement_period, predictive, policy.Forecast_lead, policy.Deleted)
     if err != nil {
         return err
     }
 
-    return nil
+    return pdb.setSteps(policy.Policy_uuid, policy.Steps)
 }
 
 func (pdb *PolicyDB) UpdatePolicy(policy Policy) error {
+    if policy.Metric_name == "" && policy.Metric_type != 0 {
+        m, ok := registry.ByType(policy.Metric_type)
+        if ok == false {
+            return errors.New("Metric_type is unknown")
+        }
+        policy.Metric_name = m.Name
+    }
+    if policy.Metric_name != "" && IsPolicyMetricName(policy.Metric_name) == false {
+        return errors.New("Metric_name is unknown")
+    }
+    stored, err := pdb.GetPolicy(policy.Policy_uuid)
+    if err != nil {
+        return err
+    }
+    merged := mergePolicy(stored, policy)
+    if err := validatePolicy(merged); err != nil {
+        return err
+    }
+    if err := validateRequired(merged); err != nil {
+        return err
+    }
+
     q := "UPDATE policies SET "
+    if policy.Policy_type != "" {
+        q = q + "policy_type = '" + policy.Policy_type + "', "
+    }
     if policy.Metric_type != 0 {
         q = q + "metric_type = " + strconv.Itoa(policy.Metric_type) + ", "
     }
+    if policy.Metric_name != "" {
+        q = q + "metric_name = '" + policy.Metric_name + "', "
+    }
     if policy.Upper_threshold != 0 {
         q = q + "upper_threshold = " + strconv.FormatFloat(policy.Upper_threshold, 'f', 6, 64) + ", "
     }
@@ -275,38 +757,88 @@ func (pdb *PolicyDB) UpdatePolicy(policy Policy) error {
     if policy.Instances_in != 0 {
         q = q + "instances_in = " + strconv.Itoa(policy.Instances_in) + ", "
     }
+    if policy.Target_value != 0 {
+        q = q + "target_value = " + strconv.FormatFloat(policy.Target_value, 'f', 6, 64) + ", "
+    }
+    if policy.Tolerance != 0 {
+        q = q + "tolerance = " + strconv.FormatFloat(policy.Tolerance, 'f', 6, 64) + ", "
+    }
+    if policy.Adjustment_type != "" {
+        q = q + "adjustment_type = '" + policy.Adjustment_type + "', "
+    }
+    if policy.Min_adjustment != 0 {
+        q = q + "min_adjustment = " + strconv.Itoa(policy.Min_adjustment) + ", "
+    }
+    if policy.Out_condition != nil { // validated, no quotes
+        q = q + "out_condition = '" + *policy.Out_condition + "', "
+    }
+    if policy.In_condition != nil {
+        q = q + "in_condition = '" + *policy.In_condition + "', "
+    }
+    if policy.Breach_evaluations != 0 {
+        q = q + "breach_evaluations = " + strconv.Itoa(policy.Breach_evaluations) + ", "
+    }
+    if policy.Breach_duration != 0 {
+        q = q + "breach_duration = " + strconv.Itoa(policy.Breach_duration) + ", "
+    }
     if policy.Cooldown_period != 0 {
         q = q + "cooldown_period = " + strconv.Itoa(policy.Cooldown_period) + ", "
     }
+    if policy.Cooldown_out != 0 {
+        q = q + "cooldown_out = " + strconv.Itoa(policy.Cooldown_out) + ", "
+    }
+    if policy.Cooldown_in != 0 {
+        q = q + "cooldown_in = " + strconv.Itoa(policy.Cooldown_in) + ", "
+    }
     if policy.Measurement_period != 0 {
         q = q + "measurement_period = " + strconv.Itoa(policy.Measurement_period) + ", "
     }
+    if policy.Forecast_lead != 0 {
+        q = q + "forecast_lead = " + strconv.Itoa(policy.Forecast_lead) + ", "
+    }
+    if policy.Per_instance != nil {
+        q = q + "per_instance = " + strconv.FormatBool(*policy.Per_instance) + ", "
+    }
+    if policy.Predictive != nil {
+        q = q + "predictive = " + strconv.FormatBool(*policy.Predictive) + ", "
+    }
 
     q = q + " deleted = " + strconv.FormatBool(policy.Deleted)
     q = q + " WHERE policy_uuid = '" + policy.Policy_uuid + "'"
 
-    _, err := pdb.db.Exec(q)
+    _, err = pdb.db.Exec(q)
     if err != nil {
         return err
     }
 
+    if policy.Steps != nil { // replace the bands
+        return pdb.setSteps(policy.Policy_uuid, policy.Steps)
+    }
     return nil
 }
 
 func (pdb *PolicyDB) GetPolicy(policy_uuid string) (Policy, error) {
     var policy Policy
-    err := pdb.db.QueryRow("SELECT app_uuid, policy_uuid, metric_type, upper_threshold, lower_threshold, instances_out, instances_in, cooldown_period, measurement_period, deleted FROM policies WHERE policy_uuid = ?", policy_uuid).Scan(&policy.App_uuid, &policy.Policy_uuid, &policy.Metric_type, &policy.Upper_threshold, &policy.Lower_threshold, &policy.Instances_out, &policy.Instances_in, &policy.Cooldown_period, &policy.Measurement_period, &policy.Deleted)
+    var per_instance, predictive bool
+    var out_condition, in_condition string
+    err := pdb.db.QueryRow("SELECT app_uuid, policy_uuid, policy_type, metric_type, metric_name, per_instance, upper_threshold, lower_threshold, instances_out, instances_in, target_value, tolerance, adjustment_type, min_adjustment, out_condition, in_condition, breach_evaluations, breach_duration, cooldown_period, cooldown_out, cooldown_in, measurement_period, predictive, forecast_lead, deleted FROM policies WHERE policy_uuid = ?", policy_uuid).Scan(&policy.App_uuid, &policy.Policy_uuid, &policy.Policy_type, &policy.Metric_type, &policy.Metric_name, &per_instance, &policy.Upper_threshold, &policy.Lower_threshold, &policy.Instances_out, &policy.Instances_in, &policy.Target_value, &policy.Tolerance, &policy.Adjustment_type, &policy.Min_adjustment, &out_condition, &in_condition, &policy.Breach_evaluations, &policy.Breach_duration, &policy.Cooldown_period, &policy.Cooldown_out, &policy.Cooldown_in, &policy.Measurement_period, &predictive, &policy.Forecast_lead, &policy.Deleted)
     if err != nil {
         log.Println("Error occurs when getting policy:", err)
         return policy, err
     }
+    policy.Per_instance, policy.Predictive = &per_instance, &predictive
+    policy.Out_condition, policy.In_condition = &out_condition, &in_condition
 
+    policy.Steps, err = pdb.getSteps(policy.Policy_uuid)
+    if err != nil {
+        return policy, err
+    }
     return policy, nil
 }
 
 func (pdb *PolicyDB) GetPolicies(app_uuid string) ([]Policy, error) {
     var policies []Policy
-    rows, err := pdb.db.Query("SELECT app_uuid, policy_uuid, metric_type, upper_threshold, lower_threshold, instances_out, instances_in, cooldown_period, measurement_period, deleted FROM policies WHERE app_uuid = ? AND deleted = false", app_uuid)
+    rows, err := pdb.db.Query("SELECT app_uuid, policy_uuid, policy_type, metric_type, metric_name, per_instance, upper_threshold, lower_threshold, instances_out, instances_in, target_value, tolerance, adjustment_type, min_adjustment, out_condition, in_condition, breach_evaluations, breach_duration, cooldown_period, cooldown_out, cooldown_in, measurement_period, predictive, forecast_lead, deleted FROM policies WHERE app_uuid = ? AND deleted = false", app_uuid)
     if err != nil {
         log.Println("Error occurs when getting policy:", err)
     }
@@ -314,12 +846,277 @@ func (pdb *PolicyDB) GetPolicies(app_uuid string) ([]Policy, error) {
 
     for rows.Next() {
         var policy Policy
-        err = rows.Scan(&policy.App_uuid, &policy.Policy_uuid, &policy.Metric_type, &policy.Upper_threshold, &policy.Lower_threshold, &policy.Instances_out, &policy.Instances_in, &policy.Cooldown_period, &policy.Measurement_period, &policy.Deleted)
+        var per_instance, predictive bool
+        var out_condition, in_condition string
+        err = rows.Scan(&policy.App_uuid, &policy.Policy_uuid, &policy.Policy_type, &policy.Metric_type, &policy.Metric_name, &per_instance, &policy.Upper_threshold, &policy.Lower_threshold, &policy.Instances_out, &policy.Instances_in, &policy.Target_value, &policy.Tolerance, &policy.Adjustment_type, &policy.Min_adjustment, &out_condition, &in_condition, &policy.Breach_evaluations, &policy.Breach_duration, &policy.Cooldown_period, &policy.Cooldown_out, &policy.Cooldown_in, &policy.Measurement_period, &predictive, &policy.Forecast_lead, &policy.Deleted)
         if err != nil {
             panic(err.Error())
         }
+        policy.Per_instance, policy.Predictive = &per_instance, &predictive
+        policy.Out_condition, policy.In_condition = &out_condition, &in_condition
         policies = append(policies, policy)
     }
+
+    for i := range policies {
+        policies[i].Steps, err = pdb.getSteps(policies[i].Policy_uuid)
+        if err != nil {
+            return policies, err
+        }
+    }
     return policies, err
 }
-// end tuna
\ No newline at end of file
+// end tuna
+
+func (pdb *PolicyDB) IsExistScrape(scrape_uuid string) (bool, error) {
+    rows, err := pdb.db.Query("SELECT Id FROM scrapes WHERE scrape_uuid = ?", scrape_uuid)
+    if err != nil {
+        log.Println("Error occurs when querying database:", err)
+        return false, err
+    }
+    defer rows.Close()
+
+    if rows.Next() {
+        return true, nil
+    } else {
+        return false, nil
+    }
+}
+
+func validateScrape(scrape Scrape) error {
+    if strings.HasPrefix(scrape.Url, "http://") == false && strings.HasPrefix(scrape.Url, "https://") == false {
+        return errors.New("Url must be http or https")
+    }
+    if scrape.Scrape_interval < 0 {
+        return errors.New("Scrape_interval is invalid")
+    }
+    if len(scrape.Mappings) == 0 {
+        return errors.New("Mappings is missing")
+    }
+    for _, m := range scrape.Mappings {
+        if m.Series == "" {
+            return errors.New("Series is missing")
+        }
+        if IsValidMetricName(m.Name) == false {
+            return errors.New("Name is invalid: " + m.Name)
+        }
+    }
+    return nil
+}
+
+func (pdb *PolicyDB) AddScrape(scrape Scrape) error {
+    if scrape.App_uuid == "" {
+        return errors.New("App_uuid is missing")
+    }
+    if scrape.Scrape_uuid == "" {
+        return errors.New("Scrape_uuid is missing")
+    }
+    if scrape.Scrape_interval == 0 {
+        scrape.Scrape_interval = 15
+    }
+    if err := validateScrape(scrape); err != nil {
+        return err
+    }
+
+    mappings, err := json.Marshal(scrape.Mappings)
+    if err != nil {
+        return err
+    }
+
+    _, err = pdb.db.Exec("INSERT INTO scrapes(app_uuid, scrape_uuid, url, scrape_interval, per_instance, mappings, deleted) VALUES (?, ?, ?, ?, ?, ?, ?)", scrape.App_uuid, scrape.Scrape_uuid, scrape.Url, scrape.Scrape_interval, scrape.Per_instance, string(mappings), scrape.Deleted)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// UpdateScrape replaces the scrape config, Url and Mappings are required.
+func (pdb *PolicyDB) UpdateScrape(scrape Scrape) error {
+    if err := validateScrape(scrape); err != nil {
+        return err
+    }
+
+    mappings, err := json.Marshal(scrape.Mappings)
+    if err != nil {
+        return err
+    }
+
+    q := "UPDATE scrapes SET url = ?, per_instance = ?, mappings = ?, "
+    args := []interface{}{scrape.Url, scrape.Per_instance, string(mappings)}
+    if scrape.Scrape_interval != 0 {
+        q = q + "scrape_interval = ?, "
+        args = append(args, scrape.Scrape_interval)
+    }
+    q = q + "deleted = ? WHERE scrape_uuid = ?"
+    args = append(args, scrape.Deleted, scrape.Scrape_uuid)
+
+    _, err = pdb.db.Exec(q, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+func scanScrape(row interface{ Scan(...interface{}) error }) (Scrape, error) {
+    var scrape Scrape
+    var mappings string
+    err := row.Scan(&scrape.App_uuid, &scrape.Scrape_uuid, &scrape.Url, &scrape.Scrape_interval, &scrape.Per_instance, &mappings, &scrape.Deleted)
+    if err != nil {
+        return scrape, err
+    }
+
+    err = json.Unmarshal([]byte(mappings), &scrape.Mappings)
+    return scrape, err
+}
+
+func (pdb *PolicyDB) GetScrape(scrape_uuid string) (Scrape, error) {
+    row := pdb.db.QueryRow("SELECT app_uuid, scrape_uuid, url, scrape_interval, per_instance, mappings, deleted FROM scrapes WHERE scrape_uuid = ?", scrape_uuid)
+    scrape, err := scanScrape(row)
+    if err != nil {
+        log.Println("Error occurs when getting scrape config:", err)
+        return scrape, err
+    }
+
+    return scrape, nil
+}
+
+func (pdb *PolicyDB) GetScrapes(app_uuid string) ([]Scrape, error) {
+    var scrapes []Scrape
+    rows, err := pdb.db.Query("SELECT app_uuid, scrape_uuid, url, scrape_interval, per_instance, mappings, deleted FROM scrapes WHERE app_uuid = ? AND deleted = false", app_uuid)
+    if err != nil {
+        log.Println("Error occurs when getting scrape configs:", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        scrape, err := scanScrape(rows)
+        if err != nil {
+            log.Println("Error occurs when parsing scrape config:", err)
+            return nil, err
+        }
+        scrapes = append(scrapes, scrape)
+    }
+    return scrapes, rows.Err()
+}
+
+func (pdb *PolicyDB) IsExistQueue(queue_uuid string) (bool, error) {
+    rows, err := pdb.db.Query("SELECT Id FROM queues WHERE queue_uuid = ?", queue_uuid)
+    if err != nil {
+        log.Println("Error occurs when querying database:", err)
+        return false, err
+    }
+    defer rows.Close()
+
+    if rows.Next() {
+        return true, nil
+    } else {
+        return false, nil
+    }
+}
+
+func (pdb *PolicyDB) AddQueue(queue Queue) error {
+    if queue.App_uuid == "" {
+        return errors.New("App_uuid is missing")
+    }
+    if queue.Queue_uuid == "" {
+        return errors.New("Queue_uuid is missing")
+    }
+    if strings.HasPrefix(queue.Api_url, "http://") == false && strings.HasPrefix(queue.Api_url, "https://") == false {
+        return errors.New("Api_url must be http or https")
+    }
+    if queue.Queue == "" {
+        return errors.New("Queue is missing")
+    }
+    if queue.Vhost == "" {
+        queue.Vhost = "/"
+    }
+    if queue.Poll_interval <= 0 {
+        queue.Poll_interval = 10
+    }
+
+    _, err := pdb.db.Exec("INSERT INTO queues(app_uuid, queue_uuid, api_url, vhost, queue, username, password, poll_interval, deleted) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", queue.App_uuid, queue.Queue_uuid, queue.Api_url, queue.Vhost, queue.Queue, queue.Username, queue.Password, queue.Poll_interval, queue.Deleted)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// UpdateQueue changes the non-empty fields of the queue, and Deleted.
+func (pdb *PolicyDB) UpdateQueue(queue Queue) error {
+    if queue.Api_url != "" && strings.HasPrefix(queue.Api_url, "http://") == false && strings.HasPrefix(queue.Api_url, "https://") == false {
+        return errors.New("Api_url must be http or https")
+    }
+
+    q := "UPDATE queues SET "
+    var args []interface{}
+    if queue.Api_url != "" {
+        q = q + "api_url = ?, "
+        args = append(args, queue.Api_url)
+    }
+    if queue.Vhost != "" {
+        q = q + "vhost = ?, "
+        args = append(args, queue.Vhost)
+    }
+    if queue.Queue != "" {
+        q = q + "queue = ?, "
+        args = append(args, queue.Queue)
+    }
+    if queue.Username != "" {
+        q = q + "username = ?, "
+        args = append(args, queue.Username)
+    }
+    if queue.Password != "" {
+        q = q + "password = ?, "
+        args = append(args, queue.Password)
+    }
+    if queue.Poll_interval > 0 {
+        q = q + "poll_interval = ?, "
+        args = append(args, queue.Poll_interval)
+    }
+    q = q + "deleted = ? WHERE queue_uuid = ?"
+    args = append(args, queue.Deleted, queue.Queue_uuid)
+
+    _, err := pdb.db.Exec(q, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// GetQueue returns the queue without its password.
+func (pdb *PolicyDB) GetQueue(queue_uuid string) (Queue, error) {
+    var queue Queue
+    err := pdb.db.QueryRow("SELECT app_uuid, queue_uuid, api_url, vhost, queue, username, poll_interval, deleted FROM queues WHERE queue_uuid = ?", queue_uuid).Scan(&queue.App_uuid, &queue.Queue_uuid, &queue.Api_url, &queue.Vhost, &queue.Queue, &queue.Username, &queue.Poll_interval, &queue.Deleted)
+    if err != nil {
+        log.Println("Error occurs when getting queue:", err)
+        return queue, err
+    }
+
+    return queue, nil
+}
+
+// GetQueues returns the queues of the app without their passwords.
+func (pdb *PolicyDB) GetQueues(app_uuid string) ([]Queue, error) {
+    var queues []Queue
+    rows, err := pdb.db.Query("SELECT app_uuid, queue_uuid, api_url, vhost, queue, username, poll_interval, deleted FROM queues WHERE app_uuid = ? AND deleted = false", app_uuid)
+    if err != nil {
+        log.Println("Error occurs when getting queues:", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var queue Queue
+        err = rows.Scan(&queue.App_uuid, &queue.Queue_uuid, &queue.Api_url, &queue.Vhost, &queue.Queue, &queue.Username, &queue.Poll_interval, &queue.Deleted)
+        if err != nil {
+            log.Println("Error occurs when parsing queue:", err)
+            return nil, err
+        }
+        queues = append(queues, queue)
+    }
+    return queues, rows.Err()
+}
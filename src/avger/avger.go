@@ -1,17 +1,23 @@
 package main
 
 import (
+    "sync"
     "time"
+
+    "registry"
+    "scaling"
 )
 
 const MAX_MEASUREMENT_PERIOD = 3600 // seconds
 
 type Avger struct {
-    Apps map[string]App
+    sync.Mutex
+    Apps map[string]*App
 }
 
 type App struct {
-    RawMetrics []RawMetric
+    // Samples of every metric, keyed by the metric name in the registry
+    Series map[string][]scaling.Sample
 
     // TODO: Pre-computed values
     Avg1m float64 // avg of 1 most recent minute
@@ -20,73 +26,60 @@ type App struct {
     Avg30m float64 // avg of 30 most recent minites
 }
 
-type RawMetric struct {
-    Time int
-    Cpu float64
-    Mem float64
+func NewAvger() *Avger {
+    return &Avger{Apps: make(map[string]*App)}
 }
 
-func (avger *Avger) AddMetric(m Metric) {
-    app, exist := avger.Apps[m.App_uuid]
+func (avger *Avger) AddSample(app_uuid string, name string, s scaling.Sample) {
+    avger.Lock()
+    defer avger.Unlock()
+
+    app, exist := avger.Apps[app_uuid]
     if exist == false {
-        avger.Apps[m.App_uuid] = app
+        app = &App{Series: make(map[string][]scaling.Sample)}
+        avger.Apps[app_uuid] = app
     }
 
-    avger.Apps[m.App_uuid].AddMetric(m)
+    app.AddSample(name, s)
 }
 
 func (avger *Avger) GetAvgMetric(r AvgRequest) Metric {
-    _, exist := avger.Apps[r.App_uuid]
-    if exist == false {
-        return Metric{}
+    m := Metric{App_uuid: r.App_uuid, Name: r.Metric, Aggregation: r.Aggregation}
+    if m.Aggregation == "" {
+        m.Aggregation = registry.AggAvg
+        if def, ok := registry.Lookup(r.Metric); ok {
+            m.Aggregation = def.Aggregation
+        }
     }
 
-    return avger.Apps[r.App_uuid].GetAvgMetric(r)
-}
+    avger.Lock()
+    defer avger.Unlock()
 
-func (app *App) AddMetric(m Metric) {
-    var rm RawMetric
-    rm.Time = time.Now().Unix()
-    rm.Cpu = m.Cpu
-    rm.Mem = m.Mem
+    app, exist := avger.Apps[r.App_uuid]
+    if exist == false {
+        return m
+    }
 
-    app.Clean()
-    append(app.RawMetrics, rm)
+    since := int(time.Now().Unix()) - r.Measurement_period
+    m.Value, m.Samples = scaling.Aggregate(app.Series[r.Metric], since, int(time.Now().Unix()), m.Aggregation)
+    return m
 }
 
-func (app *App) GetAvgMetric(r AvgRequest) Metric {
-    var m Metric
-    m.App_uuid = r.App_uuid
-
-    var counter int
-    var sum_cpu float64
-    var sum_mem float64
-    t_now := time.Now().Unix()
-    
-    for i := len(app.RawMetrics); i > 0; i-- {
-        if app.RawMetrics[i].Time + r.Measurement_period < t_now {
-            break
-        }
-        counter := counter + 1
-        sum_cpu := sum_cpu + app.RawMetrics[i].Cpu
-        sum_mem := sum_mem + app.RawMetrics[i].Mem
-    }
-
-    if counter == 0 {
-        return Metric{}
+func (app *App) AddSample(name string, s scaling.Sample) {
+    if s.Time == 0 {
+        s.Time = int(time.Now().Unix())
     }
 
-    m.Cpu = sum_cpu / counter
-    m.Mem = sum_mem / counter
-
-    return m
+    app.Series[name] = append(Clean(app.Series[name]), s)
 }
 
-func (app *App) Clean() {
-    for i, rm := range app.RawMetrics {
-        if rm.Time > time.Now().Unix() - MAX_MEASUREMENT_PERIOD {
-            app.RawMetrics = app.RawMetrics[i:]
-            return
+// Clean drops samples older than MAX_MEASUREMENT_PERIOD.
+func Clean(samples []scaling.Sample) []scaling.Sample {
+    t_min := int(time.Now().Unix()) - MAX_MEASUREMENT_PERIOD
+    for i, s := range samples {
+        if s.Time > t_min {
+            return samples[i:]
         }
     }
-}
\ No newline at end of file
+    return samples[:0]
+}
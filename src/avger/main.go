@@ -3,6 +3,7 @@ package main
 import (
     "bufio"
     "database/sql"
+    "errors"
     "flag"
     "log"
     "net"
@@ -10,14 +11,19 @@ import (
     "encoding/json"
     "os"
     "strconv"
+    "strings"
     "time"
 
     "github.com/apcera/nats"
     _ "github.com/go-sql-driver/mysql"
+
+    "registry"
+    "scaling"
 )
 
 var cfg Configuration
 var natsc *nats.Conn
+var avger *Avger
 var mdb MetricDB
 var mdb_conn *sql.DB
 
@@ -26,30 +32,32 @@ type Configuration struct {
     MonitorHost string
     MonitorPort string
     Nats string
+    Metrics string // path to the metric registry file
 }
 
+// Metric is the aggregated value of a metric of an app over a measurement period
 type Metric struct {
     App_uuid string
-    Cpu float64
-    Mem float64 
+    Name string
+    Aggregation string
+    Value float64
+    Samples int // number of samples aggregated, 0 means no data
 }
 
 type AvgRequest struct {
     App_uuid string
     Measurement_period int
+    Metric string // name in the registry
+    Aggregation string // default aggregation of the metric if empty
 }
 
 func handleMonitor(conn net.Conn) {
     scanner := bufio.NewScanner(conn)
     for scanner.Scan() {
-        elements := strings.Split(scanner.Text(), " ")
-
-        var m Metric
-        m.App_uuid = elements[0]
-        m.Cpu = strconv.ParseFloat(elements[2], 64)
-        m.Mem = strconv.ParseFloat(elements[3], 64)
-
-        avger.AddMetric(m)
+        err := handleLine(scanner.Text())
+        if err != nil {
+            log.Println("Cannot parse the input from monitor:", scanner.Text(), err)
+        }
     }
 
     if err := scanner.Err(); err != nil {
@@ -57,6 +65,50 @@ func handleMonitor(conn net.Conn) {
     }
 }
 
+// handleLine accepts two formats:
+// "app_uuid instance_uuid name=value [name=value ...]", e.g. "... cpu=12.5 mem=104857600 disk=52428800"
+// "app_uuid instance_uuid cpu mem" sent by older monitors
+func handleLine(line string) error {
+    elements := strings.Fields(line)
+    if len(elements) < 3 {
+        return errors.New("Too few fields")
+    }
+
+    app_uuid := elements[0]
+    instance_uuid := elements[1]
+    now := int(time.Now().Unix())
+
+    if strings.Contains(elements[2], "=") == false {
+        if len(elements) != 4 {
+            return errors.New("Expecting cpu and mem")
+        }
+        cpu, err := strconv.ParseFloat(elements[2], 64)
+        if err != nil {
+            return err
+        }
+        mem, err := strconv.ParseFloat(elements[3], 64)
+        if err != nil {
+            return err
+        }
+        avger.AddSample(app_uuid, "cpu", scaling.Sample{Time: now, Instance_uuid: instance_uuid, Value: cpu})
+        avger.AddSample(app_uuid, "mem", scaling.Sample{Time: now, Instance_uuid: instance_uuid, Value: mem})
+        return nil
+    }
+
+    for _, e := range elements[2:] {
+        kv := strings.SplitN(e, "=", 2)
+        if len(kv) != 2 || kv[0] == "" {
+            return errors.New("Invalid name=value pair: " + e)
+        }
+        value, err := strconv.ParseFloat(kv[1], 64)
+        if err != nil {
+            return err
+        }
+        avger.AddSample(app_uuid, kv[0], scaling.Sample{Time: now, Instance_uuid: instance_uuid, Value: value})
+    }
+    return nil
+}
+
 func HandleEngine(msg *nats.Msg) {
     log.Printf("Received on [%s]: '%s'\n", msg.Subject, string(msg.Data))
 
@@ -68,7 +120,7 @@ func HandleEngine(msg *nats.Msg) {
     }
 
     start := time.Now()
-    avgMetric := GetAvgMetric(req.App_uuid, req.Measurement_period)
+    avgMetric := avger.GetAvgMetric(req)
     end := time.Now()
     log.Println("Averaging time", end.Sub(start))
     avgMetric_json, err := json.Marshal(avgMetric)
@@ -80,22 +132,6 @@ func HandleEngine(msg *nats.Msg) {
     natsc.Publish(msg.Reply, avgMetric_json)
 }
 
-func GetAvgMetric(app_uuid string, measurement_period int) Metric {
-    // metric, err := mdb.AvgMetric(app_uuid, measurement_period)
-
-    var r AvgRequest
-    r.App_uuid = app_uuid
-    r.Measurement_period = measurement_period
-
-    metric, err := avger.GetAvgMetric(r)
-    if err != nil {
-        log.Println("Error occurs when getting avg metric:", err)
-        return Metric{}
-    }
-
-    return metric
-}
-
 func init() {
     cfgPtr := flag.String("config", "config/avger.json", "Path to the config file")
     flag.Parse()
@@ -112,13 +148,21 @@ func init() {
         os.Exit(1)
     }
 
+    if cfg.Metrics != "" {
+        err = registry.Load(cfg.Metrics)
+        if err != nil {
+            fmt.Println("Cannot load the metric registry:", err)
+            os.Exit(1)
+        }
+    }
+
     natsc, err = nats.Connect(cfg.Nats)
     if err != nil {
         fmt.Println("Cannot connect to the gnatsd:", err)
         os.Exit(1)
     }
 
-    var avger Avger
+    avger = NewAvger()
 
     // // MetricDB connection
     // mdb_dsn :=  cfg.MetricDB["Username"]+":"+
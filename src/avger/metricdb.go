@@ -2,8 +2,11 @@ package main
 
 import (
     "database/sql"
+    "errors"
     // "log"
     "time"
+
+    "registry"
 )
 
 type MetricDB struct {
@@ -11,18 +14,21 @@ type MetricDB struct {
 }
 
 // AvgMetric queries against MetricDB then returns average metric value of an application.
-// Input: Application's UUID, measurement_period (in second)
+// Input: Application's UUID, name of a collector metric (cpu, mem), measurement_period (in second)
 // Output: Average metric value of the application and error
-func (mdb *MetricDB) AvgMetric(app_uuid string, measurement_period int) (Metric, error) {
-    metric := Metric{App_uuid: app_uuid}
-    err := mdb.db.QueryRow("SELECT avg(cpu), avg(mem) FROM metrics WHERE app_uuid = ? AND created_at > ?", app_uuid, int(time.Now().Unix()) - measurement_period).Scan(&metric.Cpu, &metric.Mem)
-    if err == sql.ErrNoRows {
-        return Metric{}, nil
+func (mdb *MetricDB) AvgMetric(app_uuid string, name string, measurement_period int) (Metric, error) {
+    metric := Metric{App_uuid: app_uuid, Name: name, Aggregation: registry.AggAvg}
+    m, ok := registry.Lookup(name)
+    if ok == false || m.Source != registry.SourceCollector {
+        return metric, errors.New("Not a column of the metrics table: " + name)
     }
+
+    var value sql.NullFloat64
+    err := mdb.db.QueryRow("SELECT avg(" + name + "), count(*) FROM metrics WHERE app_uuid = ? AND created_at > ?", app_uuid, int(time.Now().Unix()) - measurement_period).Scan(&value, &metric.Samples)
     if err != nil {
         // log.Println("Error occurs when querying MetricDB: ", err)
-        // log.Println("SELECT avg(cpu), avg(mem) FROM metrics WHERE app_uuid = ? AND created_at > ?", app_uuid, int(time.Now().Unix()) - measurement_period)
         return Metric{}, err
     }
+    metric.Value = value.Float64
     return metric, nil
-}
\ No newline at end of file
+}
@@ -5,5 +5,14 @@ type App struct {
     Name string
     Min_instances int 
     Max_instances int
+    Dry_run bool
+    Next_out_time int
+    Next_in_time int
+    Flapping_until int
+    Max_in_per_hour int
+    Min_instance_age int
+    Protection_windows string
+    Protection_timezone string
     Policies []Policy
+    Breaches []Breach // of each policy
 }
\ No newline at end of file
@@ -11,10 +11,15 @@ import (
 
     _ "github.com/go-sql-driver/mysql"
     "github.com/apcera/nats"
+
+    "registry"
 )
 
 var db *sql.DB
 var duration int = 10 // seconds
+
+const MAX_BACKOFF = 3600 // seconds, between attempts to scale an app which keeps failing
+const AT_LIMIT_RECHECK = 600 // seconds, before trying again to scale an app at its limit, in case it was scaled by hand
 var cfg Configuration
 var natsc *nats.Conn
 
@@ -23,13 +28,40 @@ type Configuration struct {
     Duration int
     Nats string
     Log string
+    Metrics string // path to the metric registry file
 }
 
 type SuccessMsg struct {
     App_uuid string 
+    Scale_type string // out or in, both if empty
     Next_time int
 }
 
+// FailureMsg tells the app couldn't be scaled
+type FailureMsg struct {
+    App_uuid string
+    Scale_type string
+    At_limit bool // already at its bounds, rather than the Cloud Controller failing
+    Error string
+}
+
+// FlappingMsg holds scaling in of a flapping app until Until
+type FlappingMsg struct {
+    App_uuid string
+    Until int
+}
+
+// BreachMsg is the breach state of the policies of an app after an evaluation by the engine
+type BreachMsg struct {
+    App_uuid string
+    Breaches []struct {
+        Policy_uuid string
+        Scale string
+        Evaluations int
+        Since int
+    }
+}
+
 func Scale() {
     apps, err := GetCandidates()
     if err != nil {
@@ -44,7 +76,9 @@ func Scale() {
 
 func GetCandidates() ([]App, error) {
     apps := []App{}
-    rows, err := db.Query("SELECT app_uuid, name, min_instances, max_instances FROM apps WHERE enabled = ? AND next_time < ?", 1, time.Now().Unix())
+    // Apps are candidates as long as one direction doesn't cool down
+    now := time.Now().Unix()
+    rows, err := db.Query("SELECT app_uuid, name, min_instances, max_instances, dry_run, next_out_time, next_in_time, flapping_until, max_in_per_hour, min_instance_age, protection_windows, protection_timezone FROM apps WHERE enabled = ? AND (next_out_time < ? OR next_in_time < ?)", 1, now, now)
     if err != nil {
         log.Println("Error occurs when selecting candidates:", err)
         return apps, err
@@ -53,7 +87,7 @@ func GetCandidates() ([]App, error) {
 
     for rows.Next() {
         var app App
-        if err := rows.Scan(&app.App_uuid, &app.Name, &app.Min_instances, &app.Max_instances); err != nil {
+        if err := rows.Scan(&app.App_uuid, &app.Name, &app.Min_instances, &app.Max_instances, &app.Dry_run, &app.Next_out_time, &app.Next_in_time, &app.Flapping_until, &app.Max_in_per_hour, &app.Min_instance_age, &app.Protection_windows, &app.Protection_timezone); err != nil {
             log.Println("Error occurs when scanning rows:", err)
             continue // skip this app
         }
@@ -80,28 +114,97 @@ func AttachPoliciesTo(app *App) error {
     policies := []Policy{}
     app.Policies = policies
 
-    rows, err := db.Query("SELECT metric_type, upper_threshold, lower_threshold, instances_out, instances_in, cooldown_period, measurement_period FROM policies WHERE app_uuid = ? AND deleted = false", app.App_uuid)
+    rows, err := db.Query("SELECT policy_uuid, policy_type, metric_type, metric_name, per_instance, upper_threshold, lower_threshold, instances_out, instances_in, target_value, tolerance, adjustment_type, min_adjustment, out_condition, in_condition, breach_evaluations, breach_duration, cooldown_period, cooldown_out, cooldown_in, measurement_period, predictive, forecast_lead FROM policies WHERE app_uuid = ? AND deleted = false", app.App_uuid)
     if err != nil {
         log.Println("Error occurs when getting policies: ", err)
         return err
     }
     defer rows.Close()
 
+    index := make(map[string]int) // policy_uuid to index in app.Policies
     for rows.Next() {
         var p Policy
-        err := rows.Scan(&p.Metric_type, &p.Upper_threshold, &p.Lower_threshold, &p.Instances_out, &p.Instances_in, &p.Cooldown_period, &p.Measurement_period)
+        err := rows.Scan(&p.Policy_uuid, &p.Policy_type, &p.Metric_type, &p.Metric_name, &p.Per_instance, &p.Upper_threshold, &p.Lower_threshold, &p.Instances_out, &p.Instances_in, &p.Target_value, &p.Tolerance, &p.Adjustment_type, &p.Min_adjustment, &p.Out_condition, &p.In_condition, &p.Breach_evaluations, &p.Breach_duration, &p.Cooldown_period, &p.Cooldown_out, &p.Cooldown_in, &p.Measurement_period, &p.Predictive, &p.Forecast_lead)
         if err != nil {
             log.Println("Error occurs when parsing policy: ", err)
             return err
         }
+        if p.Metric_name == "" {
+            m, ok := registry.ByType(p.Metric_type)
+            if ok == false {
+                log.Println("Skip policy of unknown metric type:", app.App_uuid, p.Metric_type)
+                continue
+            }
+            p.Metric_name = m.Name
+        }
+        if _, ok := registry.Lookup(p.Metric_name); ok == false {
+            log.Println("Skip policy of unknown metric:", app.App_uuid, p.Metric_name)
+            continue
+        }
+        index[p.Policy_uuid] = len(app.Policies)
         app.Policies = append(app.Policies, p)
     }
     if err := rows.Err(); err != nil {
         log.Println("Error occurs when parsing policy: ", err)
         return err
     }
-    
-    return nil
+
+    if err := AttachStepsTo(app, index); err != nil {
+        return err
+    }
+    return AttachBreachesTo(app, index)
+}
+
+// AttachStepsTo loads the bands of the step policies of an app in one query.
+func AttachStepsTo(app *App, index map[string]int) error {
+    rows, err := db.Query("SELECT s.policy_uuid, s.scale_type, s.threshold, s.instances FROM policy_steps s JOIN policies p ON p.policy_uuid = s.policy_uuid WHERE p.app_uuid = ? AND p.deleted = false AND p.policy_type = 'step' ORDER BY s.threshold", app.App_uuid)
+    if err != nil {
+        log.Println("Error occurs when getting policy steps: ", err)
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var policy_uuid string
+        var s Step
+        err := rows.Scan(&policy_uuid, &s.Scale, &s.Threshold, &s.Instances)
+        if err != nil {
+            log.Println("Error occurs when parsing policy step: ", err)
+            return err
+        }
+        i, ok := index[policy_uuid]
+        if ok == false {
+            continue // Policy skipped above
+        }
+        app.Policies[i].Steps = append(app.Policies[i].Steps, s)
+    }
+    return rows.Err()
+}
+
+// AttachBreachesTo loads the breach state of the policies of an app, left by the previous evaluation.
+func AttachBreachesTo(app *App, index map[string]int) error {
+    app.Breaches = make([]Breach, len(app.Policies))
+
+    rows, err := db.Query("SELECT policy_uuid, scale_type, evaluations, since FROM breaches WHERE app_uuid = ?", app.App_uuid)
+    if err != nil {
+        log.Println("Error occurs when getting breaches: ", err)
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var policy_uuid string
+        var b Breach
+        err := rows.Scan(&policy_uuid, &b.Scale, &b.Evaluations, &b.Since)
+        if err != nil {
+            log.Println("Error occurs when parsing breach: ", err)
+            return err
+        }
+        if i, ok := index[policy_uuid]; ok {
+            app.Breaches[i] = b
+        }
+    }
+    return rows.Err()
 }
 
 func Enqueue(app App) {
@@ -137,6 +240,14 @@ func init() {
         os.Exit(1)
     }
 
+    if cfg.Metrics != "" {
+        err = registry.Load(cfg.Metrics)
+        if err != nil {
+            fmt.Println("Cannot load the metric registry:", err)
+            os.Exit(1)
+        }
+    }
+
     if cfg.Duration != 0 {
         duration = cfg.Duration
     }
@@ -165,11 +276,118 @@ func HandleSuccess(msg *nats.Msg) {
         log.Println("Error occurs when unmarshal success message: ", err)
         return // Skip this message
     }
-    SetNextTime(success_msg.App_uuid, success_msg.Next_time)
+    SetNextTime(success_msg.App_uuid, success_msg.Scale_type, success_msg.Next_time)
+    ClearFailure(success_msg.App_uuid, success_msg.Scale_type)
 }
 
-func SetNextTime(app_uuid string, next_time int) error {
-    _, err := db.Exec("UPDATE apps SET next_time = ? WHERE app_uuid = ?", next_time, app_uuid)
+func HandleFailure(msg *nats.Msg) {
+    log.Printf("Received on [%s]: '%s'\n", msg.Subject, string(msg.Data))
+    var failure_msg FailureMsg
+    err := json.Unmarshal(msg.Data, &failure_msg)
+    if err != nil {
+        log.Println("Error occurs when unmarshal failure message: ", err)
+        return // Skip this message
+    }
+
+    now := int(time.Now().Unix())
+    if failure_msg.At_limit {
+        // Scaling this way is useless until the bounds change, see the api, or the app is scaled by hand
+        _, err := db.Exec("UPDATE apps SET at_limit = ? WHERE app_uuid = ?", failure_msg.Scale_type, failure_msg.App_uuid)
+        if err != nil {
+            log.Println("Error occurs when storing at limit state: ", err)
+            return
+        }
+        DelayNextTime(failure_msg.App_uuid, failure_msg.Scale_type, now + AT_LIMIT_RECHECK)
+        return
+    }
+
+    var failures int
+    err = db.QueryRow("SELECT failures FROM apps WHERE app_uuid = ?", failure_msg.App_uuid).Scan(&failures)
+    if err != nil {
+        log.Println("Error occurs when getting failures: ", err)
+        return
+    }
+    failures = failures + 1
+    last_error := failure_msg.Error
+    if len(last_error) > 255 {
+        last_error = last_error[:255]
+    }
+
+    _, err = db.Exec("UPDATE apps SET failures = ?, last_error = ?, failed_at = ? WHERE app_uuid = ?", failures, last_error, now, failure_msg.App_uuid)
+    if err != nil {
+        log.Println("Error occurs when storing failure: ", err)
+        return
+    }
+    DelayNextTime(failure_msg.App_uuid, failure_msg.Scale_type, now + Backoff(failures))
+}
+
+// Backoff returns the seconds to wait after consecutive failures to scale an app,
+// doubling from the cycle duration up to MAX_BACKOFF.
+func Backoff(failures int) int {
+    backoff := duration
+    for i := 0; i < failures && backoff < MAX_BACKOFF; i++ {
+        backoff = backoff * 2
+    }
+    if backoff > MAX_BACKOFF {
+        backoff = MAX_BACKOFF
+    }
+    return backoff
+}
+
+func HandleFlapping(msg *nats.Msg) {
+    log.Printf("Received on [%s]: '%s'\n", msg.Subject, string(msg.Data))
+    var flapping_msg FlappingMsg
+    err := json.Unmarshal(msg.Data, &flapping_msg)
+    if err != nil {
+        log.Println("Error occurs when unmarshal flapping message: ", err)
+        return // Skip this message
+    }
+
+    _, err = db.Exec("UPDATE apps SET flapping_until = ?, next_in_time = GREATEST(next_in_time, ?) WHERE app_uuid = ?", flapping_msg.Until, flapping_msg.Until, flapping_msg.App_uuid)
+    if err != nil {
+        log.Println("Error occurs when storing flapping state: ", err)
+    }
+}
+
+// ClearFailure resets the failures of the app, and its limit if it was
+// reached in the direction scale, the app scaled so. Both if scale is empty.
+func ClearFailure(app_uuid string, scale string) error {
+    _, err := db.Exec("UPDATE apps SET failures = 0, at_limit = IF(? = '' OR at_limit = ?, '', at_limit) WHERE app_uuid = ?", scale, scale, app_uuid)
+    if err != nil {
+        log.Println("ClearFailure failed: ", err)
+        return err
+    }
+    return nil
+}
+
+func HandleBreach(msg *nats.Msg) {
+    var breach_msg BreachMsg
+    err := json.Unmarshal(msg.Data, &breach_msg)
+    if err != nil {
+        log.Println("Error occurs when unmarshal breach message: ", err)
+        return // Skip this message
+    }
+
+    for _, b := range breach_msg.Breaches {
+        _, err := db.Exec("INSERT INTO breaches (policy_uuid, app_uuid, scale_type, evaluations, since) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE scale_type = VALUES(scale_type), evaluations = VALUES(evaluations), since = VALUES(since)", b.Policy_uuid, breach_msg.App_uuid, b.Scale, b.Evaluations, b.Since)
+        if err != nil {
+            log.Println("Error occurs when storing breach: ", err)
+            return
+        }
+    }
+}
+
+// SetNextTime sets the time until which scaling the app in the direction scale cools down.
+func SetNextTime(app_uuid string, scale string, next_time int) error {
+    var err error
+    switch scale {
+        case "out":
+            _, err = db.Exec("UPDATE apps SET next_out_time = ? WHERE app_uuid = ?", next_time, app_uuid)
+        case "in":
+            _, err = db.Exec("UPDATE apps SET next_in_time = ? WHERE app_uuid = ?", next_time, app_uuid)
+        default:
+            _, err = db.Exec("UPDATE apps SET next_out_time = ?, next_in_time = ? WHERE app_uuid = ?", next_time, next_time, app_uuid)
+    }
     if err != nil {
         log.Println("SetNextTime failed: ", err)
         return err 
@@ -177,11 +395,33 @@ func SetNextTime(app_uuid string, next_time int) error {
     return nil
 }
 
+// DelayNextTime postpones scaling the app in the direction scale, both if
+// empty, until next_time at least: a running cooldown is never shortened.
+func DelayNextTime(app_uuid string, scale string, next_time int) error {
+    var err error
+    switch scale {
+        case "out":
+            _, err = db.Exec("UPDATE apps SET next_out_time = GREATEST(next_out_time, ?) WHERE app_uuid = ?", next_time, app_uuid)
+        case "in":
+            _, err = db.Exec("UPDATE apps SET next_in_time = GREATEST(next_in_time, ?) WHERE app_uuid = ?", next_time, app_uuid)
+        default:
+            _, err = db.Exec("UPDATE apps SET next_out_time = GREATEST(next_out_time, ?), next_in_time = GREATEST(next_in_time, ?) WHERE app_uuid = ?", next_time, next_time, app_uuid)
+    }
+    if err != nil {
+        log.Println("DelayNextTime failed: ", err)
+        return err
+    }
+    return nil
+}
+
 func main() {
     defer db.Close()
     defer natsc.Close()
 
     natsc.Subscribe("success", HandleSuccess)
+    natsc.Subscribe("breach", HandleBreach)
+    natsc.Subscribe("failure", HandleFailure)
+    natsc.Subscribe("flapping", HandleFlapping)
 
     ticker := time.NewTicker(time.Duration(duration) * time.Second)
 
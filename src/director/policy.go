@@ -1,11 +1,41 @@
 package main 
 
 type Policy struct {
-    Metric_type int 
+    Policy_uuid string
+    Policy_type string // threshold, target, step or condition
+    Metric_type int // legacy, used when Metric_name is empty
+    Metric_name string // name in the metric registry
+    Per_instance bool // thresholds are per instance, the metric is divided by the number of instances
     Upper_threshold float64
     Lower_threshold float64
     Instances_out int
     Instances_in int
+    Target_value float64
+    Tolerance float64
+    Steps []Step
+    Adjustment_type string // count or percent
+    Min_adjustment int
+    Out_condition string
+    In_condition string
+    Breach_evaluations int
+    Breach_duration int
     Cooldown_period int
+    Cooldown_out int
+    Cooldown_in int
     Measurement_period int
+    Predictive bool // the forecast of the metric is compared when higher than the observed value
+    Forecast_lead int
+}
+
+type Step struct {
+    Scale string // out or in
+    Threshold float64
+    Instances int
+}
+
+// Breach is the state of a policy across evaluations, see BreachMsg
+type Breach struct {
+    Scale string // out or in, empty when the policy decided nothing
+    Evaluations int // consecutive evaluations which decided Scale
+    Since int
 }
\ No newline at end of file
@@ -1,9 +1,43 @@
 package main 
 
+import (
+    "time"
+
+    "scaling"
+)
+
 type Application struct {
     App_uuid string
     Name string
     Min_instances int 
     Max_instances int
-    Policies []Policy
-}
\ No newline at end of file
+    Dry_run bool // record the decisions without scaling
+    Next_out_time int // unix time until which scaling out cools down
+    Next_in_time int // unix time until which scaling in cools down
+    Flapping_until int // unix time until which scaling in is held because the app flapped
+    Max_in_per_hour int // instances which may be removed within an hour, unlimited if 0
+    Min_instance_age int // seconds since the last scale out before scaling in
+    Protection_windows string // e.g. "mon-fri 09:00-18:00", scaling in is blocked within them
+    Protection_timezone string // of the windows, UTC if empty
+    Policies []scaling.Policy
+    Breaches []scaling.Breach // of each policy, left by the previous evaluation
+}
+
+// Protection of the app against scaling in
+func (app Application) Protection() (scaling.Protection, error) {
+    p := scaling.Protection{Max_in_per_hour: app.Max_in_per_hour, Min_instance_age: app.Min_instance_age}
+
+    windows, err := scaling.ParseWindows(app.Protection_windows)
+    if err != nil {
+        return p, err
+    }
+    p.Windows = windows
+
+    if app.Protection_timezone != "" {
+        p.Location, err = time.LoadLocation(app.Protection_timezone)
+        if err != nil {
+            return p, err
+        }
+    }
+    return p, nil
+}
@@ -1,12 +1,13 @@
 package main 
 
 import (
-    "errors"
     "net/http"
     "log"
     "strings"
     "io/ioutil"
     "encoding/json"
+
+    "scaling"
 )
 
 type CCClient struct {
@@ -30,64 +31,40 @@ type App struct {
     Instances int
 }
 
-func (c *CCClient) ScaleOut(app_uuid string, num int, max int) (num_after int, err error) {
-    errMaximum := errors.New("Already at maximum number of instances")
+// ScaleOut adds the instances of a scale out decision, as many as Delta rounds them to, up to max.
+func (c *CCClient) ScaleOut(app_uuid string, d scaling.Decision, max int) (num_before int, num_after int, err error) {
+    return c.scale(app_uuid, d, 0, max)
+}
 
-    num_current, err := c.getNumInstances(app_uuid)
-    if err != nil {
-        log.Println("Error occurs when getting number of instances: ", err)
-        return 0, err
-    }
-    if num_current > max { // It happens when users did manual scaling
-        return num_current, errMaximum
-    }
-    if num_current == max {
-        return max, errMaximum
-    }
-    if num_current + num > max {
-        err := c.setNumInstances(app_uuid, max)
-        if err != nil {
-            log.Println("Error occurs when scaling out: ", err)
-            return num_current, err
-        }
-        return max, nil
-    }
-    err1 := c.setNumInstances(app_uuid, num_current + num)
-    if err1 != nil {
-        log.Println("Error occurs when scaling out: ", err)
-        return num_current, err
-    }
-    return num_current + num, nil
+// ScaleIn removes the instances of a scale in decision, as many as Delta rounds them to, down to min.
+func (c *CCClient) ScaleIn(app_uuid string, d scaling.Decision, min int) (num_before int, num_after int, err error) {
+    return c.scale(app_uuid, d, min, 0)
 }
 
-func (c *CCClient) ScaleIn(app_uuid string, num int, min int) (num_after int, err error) {
-    errMinimum := errors.New("Already at minimum number of instances")
+// ScaleTo sets the number of instances to the Desired of a target policy decision, within [min, max].
+func (c *CCClient) ScaleTo(app_uuid string, d scaling.Decision, min int, max int) (num_before int, num_after int, err error) {
+    return c.scale(app_uuid, d, min, max)
+}
 
+// scale sets the number of instances of the app to the target of the decision.
+func (c *CCClient) scale(app_uuid string, d scaling.Decision, min int, max int) (num_before int, num_after int, err error) {
     num_current, err := c.getNumInstances(app_uuid)
     if err != nil {
         log.Println("Error occurs when getting number of instances: ", err)
-        return 0, err
-    }
-    if num_current < min { // It happens when users did manual scaling
-        return num_current, errMinimum
-    }
-    if num_current == min {
-        return min, errMinimum
+        return 0, 0, err
     }
-    if num_current - num < min {
-        err := c.setNumInstances(app_uuid, min)
-        if err != nil {
-            log.Println("Error occurs when scaling out: ", err)
-            return num_current, err
-        }
-        return min, nil
+
+    num_after, err = scaling.Target(d, num_current, min, max)
+    if err != nil {
+        return num_current, num_after, err
     }
-    err1 := c.setNumInstances(app_uuid, num_current - num)
-    if err1 != nil {
-        log.Println("Error occurs when scaling out: ", err)
-        return num_current, err
+
+    err = c.setNumInstances(app_uuid, num_after)
+    if err != nil {
+        log.Println("Error occurs when scaling", d.Scale, ": ", err)
+        return num_current, num_current, err
     }
-    return num_current - num, nil
+    return num_current, num_after, nil
 }
 
 func (c *CCClient) getNumInstances(app_uuid string) (num int, err error) {
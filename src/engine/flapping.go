@@ -0,0 +1,48 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+
+    "scaling"
+)
+
+// An app is flapping when its scaling reverses direction, e.g. out, in, out,
+// at least flap_reversals times within flap_window seconds. It is then held at
+// its higher number of instances: scaling in cools down for flap_hold seconds.
+var flap_window int = scaling.DefaultFlapWindow // seconds
+var flap_reversals int = scaling.DefaultFlapReversals
+var flap_hold int = scaling.DefaultFlapHold // seconds
+
+// FlappingMsg tells the director to hold scaling in of a flapping app
+type FlappingMsg struct {
+    App_uuid string
+    Until int
+}
+
+// Damp detects whether the app flaps from its scalings, those of its dry-run
+// decisions for a dry-run app, unless it is already
+// held, in which case it records a flapping event and holds scaling in. It
+// returns the unix time until which scaling in is held.
+func Damp(app Application, history []scaling.Scaling, now int) int {
+    if app.Flapping_until > now {
+        return app.Flapping_until
+    }
+
+    reversals := scaling.Reversals(history, now - flap_window)
+    if reversals < flap_reversals {
+        return app.Flapping_until
+    }
+
+    until := now + flap_hold
+    log.Println(app.Name, "Flapping:", reversals, "reversals in", flap_window, "seconds, scaling in held for", flap_hold, "seconds")
+    StoreEvent(app.App_uuid, app.Name, Metadata{Status: 1, Flapping: true, Reversals: reversals, DryRun: app.Dry_run})
+
+    msg_json, err := json.Marshal(FlappingMsg{App_uuid: app.App_uuid, Until: until})
+    if err != nil {
+        log.Println("Encode flapping message to json failed:", err)
+        return until
+    }
+    natsc.Publish("flapping", msg_json)
+    return until
+}
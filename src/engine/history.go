@@ -0,0 +1,45 @@
+package main
+
+import (
+    "encoding/json"
+
+    "scaling"
+)
+
+// History returns the events the engine stored for the app since the unix
+// time since, in chronological order.
+func History(app_uuid string, since int) ([]Metadata, error) {
+    q := "SELECT metadata FROM event WHERE actor_name = $1 AND actee = $2 AND created_at > to_timestamp($3) ORDER BY created_at"
+    rows, err := hdb.Query(q, "citusscaler", app_uuid, since)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var history []Metadata
+    for rows.Next() {
+        var metadata string
+        if err := rows.Scan(&metadata); err != nil {
+            return nil, err
+        }
+        var m Metadata
+        if err := json.Unmarshal([]byte(metadata), &m); err != nil {
+            continue // Not ours
+        }
+        history = append(history, m)
+    }
+    return history, rows.Err()
+}
+
+// Scalings returns the scalings of the history, only the decisions of a
+// dry-run app or only the real ones.
+func Scalings(history []Metadata, dry_run bool) []scaling.Scaling {
+    var list []scaling.Scaling
+    for _, m := range history {
+        if m.Flapping || m.DryRun != dry_run {
+            continue
+        }
+        list = append(list, scaling.Scaling{Time: m.CreatedAt, Scale: m.Scale, Num_before: m.NumBefore, Num_after: m.NumAfter})
+    }
+    return list
+}
@@ -1,6 +1,9 @@
 package main 
 
 import (
+    "crypto/rand"
+    "database/sql"
+    "encoding/hex"
     "encoding/json"
     "flag"
     "fmt"
@@ -9,26 +12,68 @@ import (
     "time"
 
     "github.com/apcera/nats"
+    _ "github.com/lib/pq"
+
+    "registry"
+    "scaling"
 )
 
 var ccc CCClient
 var cfg Configuration
 var natsc *nats.Conn
+var hdb *sql.DB
 
 type Configuration struct {
     CloudController map[string]string
+    HistoryDB map[string]string
     Nats string
     Log string
+    Metrics string // path to the metric registry file
+    FlapWindow int // seconds, see flapping.go
+    FlapReversals int
+    FlapHold int // seconds
 }
 
 type SuccessMsg struct {
     App_uuid string 
+    Scale_type string // direction which cools down until Next_time
     Next_time int
 }
 
+// FailureMsg tells the director the app couldn't be scaled, for it to back off
+type FailureMsg struct {
+    App_uuid string
+    Scale_type string
+    At_limit bool // the app is already at its bounds, rather than the Cloud Controller failing
+    Error string
+}
+
+// BreachMsg carries the breach state of the policies of an app, for the director to keep until the next evaluation
+type BreachMsg struct {
+    App_uuid string
+    Breaches []PolicyBreach
+}
+
+type PolicyBreach struct {
+    Policy_uuid string
+    Scale string
+    Evaluations int
+    Since int
+}
+
 type AvgRequest struct {
     App_uuid string
     Measurement_period int
+    Metric string // name in the registry
+    Aggregation string // default aggregation of the metric if empty
+}
+
+// ForecastRequest asks the forecaster for the forecast of a metric at Time
+type ForecastRequest struct {
+    App_uuid string
+    Metric string // name in the registry
+    Aggregation string // default aggregation of the metric if empty
+    Time int
 }
 
 func init() {
@@ -47,12 +92,43 @@ func init() {
         os.Exit(1)
     }
 
+    if cfg.Metrics != "" {
+        err = registry.Load(cfg.Metrics)
+        if err != nil {
+            fmt.Println("Cannot load the metric registry:", err)
+            os.Exit(1)
+        }
+    }
+
+    if cfg.FlapWindow != 0 {
+        flap_window = cfg.FlapWindow
+    }
+    if cfg.FlapReversals != 0 {
+        flap_reversals = cfg.FlapReversals
+    }
+    if cfg.FlapHold != 0 {
+        flap_hold = cfg.FlapHold
+    }
+
     ccc = CCClient {
         api_host: cfg.CloudController["Api_host"],
         auth_host: cfg.CloudController["Auth_host"],
         auth_user: cfg.CloudController["Auth_user"],
         auth_pass: cfg.CloudController["Auth_pass"]}
 
+    // postgresql://[user[:password]@][netloc][:port][/dbname][?param1=value1&...]
+    hdb_dsn := "postgresql://"+
+                cfg.HistoryDB["Username"]+":"+
+                cfg.HistoryDB["Password"]+"@"+
+                cfg.HistoryDB["Host"]+":"+
+                cfg.HistoryDB["Port"]+"/"+
+                cfg.HistoryDB["Database"]
+    hdb, err = sql.Open("postgres", hdb_dsn)
+    if err != nil {
+        fmt.Println("Cannot connect to the History database:", err)
+        os.Exit(1)
+    }
+
     natsc, err = nats.Connect(cfg.Nats)
     if err != nil {
         fmt.Println("Cannot connect to the gnatsd:", err)
@@ -83,55 +159,128 @@ func Scale(msg *nats.Msg) {
 }
 
 func HandleScaling(app Application) {
-    for _, policy := range app.Policies {
-        start := time.Now()
-        avg_metric, err := GetAvgMetric(app.App_uuid, policy.Measurement_period)
-        end := time.Now()
-        if err != nil {
-            log.Println("Error occurs when getting avg metric:", err)
-            continue // Skip this policy
-        }
+    breaches := make([]scaling.Breach, len(app.Policies))
+    copy(breaches, app.Breaches)
 
-        var m float64
-        var m_type string
-        switch policy.Metric_type {
-            case 0: // CPU
-                m = avg_metric.Cpu
-                m_type = "CPU"
-            case 1: // Mem
-                m = avg_metric.Mem
-                m_type = "Mem"
+    now := int(time.Now().Unix())
+    next_in_time := app.Next_in_time
+    holds, limits := map[string]string{}, map[string]int{}
+
+    // Scaling in is held, rather than risked, when its protection can't be checked
+    period := 3600
+    if flap_window > period {
+        period = flap_window
+    }
+    if app.Min_instance_age > period {
+        period = app.Min_instance_age
+    }
+    protection, err := app.Protection()
+    history, err_history := History(app.App_uuid, now - period)
+    if err != nil {
+        log.Println(app.Name, "Invalid protection:", err)
+        holds[scaling.ScaleIn] = "invalid protection"
+    } else if err_history != nil {
+        log.Println(app.Name, "Error occurs when getting history:", err_history)
+        holds[scaling.ScaleIn] = "history unavailable"
+    } else {
+        scalings := Scalings(history, app.Dry_run)
+        if held := Damp(app, scalings, now); held > next_in_time {
+            next_in_time = held
         }
+        holds, limits = protection.Restrict(now, scalings)
+    }
+
+    e := scaling.Evaluator{
+        Policies: app.Policies,
+        Observe: func(policy scaling.Policy, metric registry.Metric) (float64, string, int, error) {
+            start := time.Now()
+            avg_metric, err := GetAvgMetric(app.App_uuid, metric.Name, metric.Aggregation, policy.Measurement_period)
+            log.Println(app.Name, metric.Name, "Averaging time:", time.Now().Sub(start))
+            return avg_metric.Value, avg_metric.Aggregation, avg_metric.Samples, err
+        },
+        Forecast: func(policy scaling.Policy, metric registry.Metric, at int) (float64, bool, error) {
+            forecast, err := GetForecast(app.App_uuid, metric.Name, metric.Aggregation, at)
+            return forecast.Value, forecast.Samples > 0, err
+        },
+        Instances: func() (int, error) {
+            return ccc.getNumInstances(app.App_uuid)
+        },
+        Log: func(v ...interface{}) {
+            log.Println(append([]interface{}{app.Name}, v...)...)
+        },
+        Breaches: breaches,
+        Now: now,
+        Cooldowns: map[string]int{scaling.ScaleOut: app.Next_out_time, scaling.ScaleIn: next_in_time},
+        Holds: holds,
+        Limits: limits}
+    defer HandleBreach(app, breaches)
 
-        log.Println(app.Name, m_type, "avg =", m, ", U =", policy.Upper_threshold, ", L =", policy.Lower_threshold, ", Averaging time:", end.Sub(start))
+    e.Run(func(d scaling.Decision) bool {
+        m := Metadata{
+            Scale: d.Scale,
+            Metric: d.Metric,
+            Value: d.Value,
+            Threshold: d.Threshold,
+            Status: 1,
+            Percent: d.Percent,
+            Resolution: d.Resolution,
+            Forecast: d.Forecast,
+            DryRun: app.Dry_run}
 
-        if m > policy.Upper_threshold {
+        if app.Dry_run {
+            num, err := ccc.getNumInstances(app.App_uuid)
+            if err != nil {
+                log.Println(app.Name, "Error occurs when getting number of instances:", err)
+                HandleFailure(app.App_uuid, d.Scale, err)
+                return false
+            }
+            num_after, err := scaling.Target(d, num, app.Min_instances, app.Max_instances)
+            if err != nil {
+                log.Println(app.Name, "Dry run: scaling", d.Scale, "skipped", err)
+                HandleFailure(app.App_uuid, d.Scale, err)
+                return false
+            }
+            log.Println(app.Name, "Dry run: scale", d.Scale, "from", num, "to", num_after)
+            m.NumBefore, m.NumAfter = num, num_after
+        } else if d.Desired > 0 {
+            log.Println(app.Name, "Scale", d.Scale, "to", d.Desired)
+            num, num_after, err := ccc.ScaleTo(app.App_uuid, d, app.Min_instances, app.Max_instances)
+            if err != nil {
+                log.Println(app.Name, "Scaling to", d.Desired, "failed", err)
+                HandleFailure(app.App_uuid, d.Scale, err)
+                return false
+            }
+            m.NumBefore, m.NumAfter = num, num_after
+        } else if d.Scale == scaling.ScaleOut {
             log.Println(app.Name, "Scale out")
-            num_after, err := ccc.ScaleOut(app.App_uuid, policy.Instances_out, app.Max_instances)
+            num, num_after, err := ccc.ScaleOut(app.App_uuid, d, app.Max_instances)
             if err != nil {
                 log.Println(app.Name, "Scaling out failed", err)
-                continue
+                HandleFailure(app.App_uuid, d.Scale, err)
+                return false
             }
-            StoreEvent(app.App_uuid, app.Name, m_type, m, policy.Upper_threshold, policy.Instances_out, num_after)
-            HandleSuccess(app.App_uuid, int(time.Now().Unix()) + policy.Cooldown_period)
-            return
+            m.NumBefore, m.NumAfter = num, num_after
         } else {
-            if m < policy.Lower_threshold {
-                log.Println(app.Name, "Scale in")
-                _, err := ccc.ScaleIn(app.App_uuid, policy.Instances_in, app.Min_instances)
-                if err != nil {
-                    log.Println(app.Name, "Scaling in failed", err)
-                    continue
-                }
-                HandleSuccess(app.App_uuid, int(time.Now().Unix()) + policy.Cooldown_period)
-                return
+            log.Println(app.Name, "Scale in")
+            num, num_after, err := ccc.ScaleIn(app.App_uuid, d, app.Min_instances)
+            if err != nil {
+                log.Println(app.Name, "Scaling in failed", err)
+                HandleFailure(app.App_uuid, d.Scale, err)
+                return false
             }
+            m.NumBefore, m.NumAfter = num, num_after
         }
-    }
+        m.InstancesOut = scaling.Delta(d, m.NumBefore) // before clamping to the bounds
+
+        StoreEvent(app.App_uuid, app.Name, m)
+        // Dry-run apps cool down too, so that their history reads like the real one would
+        HandleSuccess(app.App_uuid, d.Scale, int(time.Now().Unix()) + d.Cooldown)
+        return true
+    })
 }
 
-func GetAvgMetric(app_uuid string, measurement_period int) (Metric, error) {
-    req := AvgRequest{App_uuid: app_uuid, Measurement_period: measurement_period}
+func GetAvgMetric(app_uuid string, name string, aggregation string, measurement_period int) (Metric, error) {
+    req := AvgRequest{App_uuid: app_uuid, Measurement_period: measurement_period, Metric: name, Aggregation: aggregation}
     req_json, err := json.Marshal(req)
     if err != nil {
         log.Println("Error occurs when encoding avg request:", err)
@@ -154,8 +303,66 @@ func GetAvgMetric(app_uuid string, measurement_period int) (Metric, error) {
     return avgMetric, nil
 }
 
-func HandleSuccess(app_uuid string, next_time int) {
-    msg := SuccessMsg{App_uuid: app_uuid, Next_time: next_time}
+// GetForecast asks the forecaster for the forecast of a metric of the app at
+// the unix time at, Samples is 0 when there is none.
+func GetForecast(app_uuid string, name string, aggregation string, at int) (Metric, error) {
+    req := ForecastRequest{App_uuid: app_uuid, Metric: name, Aggregation: aggregation, Time: at}
+    req_json, err := json.Marshal(req)
+    if err != nil {
+        log.Println("Error occurs when encoding forecast request:", err)
+        return Metric{}, err
+    }
+
+    res, err := natsc.Request("forecast", req_json, 1000*time.Millisecond)
+    if err != nil {
+        log.Println("Error occurs when requesting forecast:", err)
+        return Metric{}, err
+    }
+
+    var forecast Metric
+    err = json.Unmarshal(res.Data, &forecast)
+    if err != nil {
+        log.Println("Error occurs when decoding forecast response:", string(res.Data))
+        return Metric{}, err
+    }
+
+    return forecast, nil
+}
+
+// HandleBreach sends the breach state of the policies after an evaluation, if it changed.
+func HandleBreach(app Application, breaches []scaling.Breach) {
+    msg := BreachMsg{App_uuid: app.App_uuid}
+    changed := false
+    for i, b := range breaches {
+        if i >= len(app.Breaches) || b != app.Breaches[i] {
+            changed = true
+        }
+        msg.Breaches = append(msg.Breaches, PolicyBreach{Policy_uuid: app.Policies[i].Policy_uuid, Scale: b.Scale, Evaluations: b.Evaluations, Since: b.Since})
+    }
+    if changed == false {
+        return
+    }
+
+    msg_json, err := json.Marshal(msg)
+    if err != nil {
+        log.Println("Encode breach message to json failed:", err)
+        return
+    }
+    natsc.Publish("breach", msg_json)
+}
+
+func HandleFailure(app_uuid string, scale string, err error) {
+    msg := FailureMsg{App_uuid: app_uuid, Scale_type: scale, At_limit: err == scaling.ErrMaximum || err == scaling.ErrMinimum, Error: err.Error()}
+    msg_json, err := json.Marshal(msg)
+    if err != nil {
+        log.Println("Encode failure message to json failed:", err)
+        return
+    }
+    natsc.Publish("failure", msg_json)
+}
+
+func HandleSuccess(app_uuid string, scale string, next_time int) {
+    msg := SuccessMsg{App_uuid: app_uuid, Scale_type: scale, Next_time: next_time}
     msg_json, err := json.Marshal(msg)
     if err != nil {
         log.Println("Decode app to json failed:", err)
@@ -178,8 +385,26 @@ We just care about these column:
 + actor_name = citusscaler
 + actee_name = <app_name>
 */
-func StoreEvent(app_uuid string, app_name string, ) {
+func StoreEvent(app_uuid string, app_name string, m Metadata) {
+    m.CreatedAt = int(time.Now().Unix())
+    metadata, err := json.Marshal(m)
+    if err != nil {
+        log.Println("Error occurs when encoding event metadata:", err)
+        return
+    }
 
+    guid := make([]byte, 16)
+    if _, err := rand.Read(guid); err != nil {
+        log.Println("Error occurs when generating event guid:", err)
+        return
+    }
+
+    q := "INSERT INTO event (guid, created_at, updated_at, timestamp, type, actor, actor_type, actee, actee_type, metadata, actor_name, actee_name) " +
+        "VALUES ($1, to_timestamp($2), to_timestamp($2), to_timestamp($2), $3, $4, $5, $6, $7, $8, $9, $10)"
+    _, err = hdb.Exec(q, hex.EncodeToString(guid), m.CreatedAt, "app.autoscaling", app_uuid, "app", app_uuid, "app", string(metadata), "citusscaler", app_name)
+    if err != nil {
+        log.Println("Error occurs when storing event of", app_name, err)
+    }
 }
 
 func main() {
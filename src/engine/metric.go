@@ -1,7 +1,10 @@
 package main
 
+// Metric is the aggregated value of a metric of an app over a measurement period, computed by the avger
 type Metric struct {
     App_uuid string
-    Cpu float64
-    Mem float64 
-}
\ No newline at end of file
+    Name string
+    Aggregation string
+    Value float64
+    Samples int // number of samples aggregated, 0 means no data
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "math"
+    "os"
+    "time"
+
+    _ "github.com/go-sql-driver/mysql"
+    "github.com/apcera/nats"
+
+    "registry"
+    "scaling"
+)
+
+// The forecaster models the history of every metric predictive policies refer
+// to with Holt-Winters, one model per app and metric, and stores the forecast
+// of the next Horizon seconds in MetricDB. The engine asks for the forecasts
+// over NATS, the api serves them for review.
+var pdb *sql.DB
+var mdb MetricDB
+var cfg Configuration
+var natsc *nats.Conn
+
+type Configuration struct {
+    PolicyDB map[string]string
+    MetricDB map[string]string
+    Backend string // where samples are kept, as for the monitor and the api: only "mysql" (default) is read
+    Nats string
+    Metrics string // path to the metric registry file
+    Duration int // seconds between runs
+    Step int // seconds, width of the buckets of the series, e.g. 900
+    Season int // seconds, e.g. 86400 for daily or 604800 for weekly traffic
+    History int // seconds of history the models are fitted to, at least two seasons
+    Horizon int // seconds forecast after each run
+    Retention int // seconds, forecasts of older buckets are purged, kept for review until then
+    Log string
+}
+
+// ForecastRequest asks for the forecast of a metric of an app at Time
+type ForecastRequest struct {
+    App_uuid string
+    Metric string // name in the registry
+    Aggregation string // default aggregation of the metric if empty
+    Time int
+}
+
+// Metric is the forecast value of a metric, as the avger answers observed ones
+type Metric struct {
+    App_uuid string
+    Name string
+    Aggregation string
+    Value float64
+    Samples int // 1, or 0 when there is no forecast
+}
+
+// Target is a metric of an app to forecast
+type Target struct {
+    App_uuid string
+    Metric registry.Metric
+}
+
+// Targets returns the metrics of the enabled apps which predictive policies refer to.
+func Targets() ([]Target, error) {
+    rows, err := pdb.Query("SELECT DISTINCT p.app_uuid, p.metric_type, p.metric_name FROM policies p JOIN apps a ON a.app_uuid = p.app_uuid WHERE a.enabled = ? AND p.predictive = ? AND p.deleted = false", 1, 1)
+    if err != nil {
+        log.Println("Error occurs when querying predictive policies:", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    var targets []Target
+    seen := make(map[string]bool)
+    for rows.Next() {
+        var app_uuid string
+        var policy scaling.Policy
+        if err := rows.Scan(&app_uuid, &policy.Metric_type, &policy.Metric_name); err != nil {
+            log.Println("Error occurs when parsing row:", err)
+            return nil, err
+        }
+        metric, ok := scaling.PolicyMetric(policy)
+        if ok == false {
+            log.Println(app_uuid, "Unknown metric of policy:", policy.Metric_name, policy.Metric_type)
+            continue
+        }
+        if seen[app_uuid + " " + metric.Name] {
+            continue // e.g. by metric_type and by metric_name
+        }
+        seen[app_uuid + " " + metric.Name] = true
+        targets = append(targets, Target{App_uuid: app_uuid, Metric: metric})
+    }
+    return targets, rows.Err()
+}
+
+// Forecast fits a model to the history of a target until end and stores the
+// forecast of the buckets from end on.
+func (t Target) Forecast(end int, now int) error {
+    season := cfg.Season / cfg.Step
+    series, err := mdb.LoadSeries(t.App_uuid, t.Metric, end - cfg.History, end, cfg.Step)
+    if err != nil {
+        return err
+    }
+
+    // Leave out the buckets before the first sample, e.g. of a new app
+    first := 0
+    for first < len(series) && math.IsNaN(series[first]) {
+        first = first + 1
+    }
+    series = series[first:]
+    if len(series) < 2 * season {
+        log.Println(t.App_uuid, t.Metric.Name, "Not enough history to forecast:", len(series) * cfg.Step, "of", 2 * cfg.Season, "seconds")
+        return nil
+    }
+    missing := scaling.FillGaps(series, season)
+
+    values, model, err := scaling.FitHoltWinters(series, season, cfg.Horizon / cfg.Step)
+    if err != nil {
+        return err
+    }
+    log.Println(t.App_uuid, t.Metric.Name, "Fitted alpha =", model.Alpha, ", beta =", model.Beta, ", gamma =", model.Gamma, ", rmse =", model.Rmse, ",", missing, "of", len(series), "buckets missing")
+
+    forecasts := make([]Forecast, len(values))
+    for h, v := range values {
+        if v < 0 { // The trend overshot, metrics aren't negative
+            v = 0
+        }
+        forecasts[h] = Forecast{Time: end + h * cfg.Step, Value: v}
+    }
+    return mdb.StoreForecasts(t.App_uuid, t.Metric, cfg.Step, forecasts, now)
+}
+
+func Run() {
+    now := int(time.Now().Unix())
+    end := now / cfg.Step * cfg.Step // The current bucket is forecast, not partially observed
+
+    targets, err := Targets()
+    if err != nil {
+        return // Skip this run
+    }
+    for _, t := range targets {
+        if err := t.Forecast(end, now); err != nil {
+            log.Println(t.App_uuid, t.Metric.Name, "Error occurs when forecasting:", err)
+        }
+    }
+
+    if cfg.Retention > 0 {
+        mdb.PurgeForecasts(now - cfg.Retention)
+    }
+}
+
+// HandleForecast answers the forecast of a metric to the engine.
+func HandleForecast(msg *nats.Msg) {
+    var req ForecastRequest
+    err := json.Unmarshal(msg.Data, &req)
+    if err != nil {
+        log.Println("Error occurs when decoding forecast request:", err)
+        return
+    }
+
+    m := Metric{App_uuid: req.App_uuid, Name: req.Metric, Aggregation: req.Aggregation}
+    if m.Aggregation == "" {
+        m.Aggregation = registry.AggAvg
+        if def, ok := registry.Lookup(req.Metric); ok {
+            m.Aggregation = def.Aggregation
+        }
+    }
+
+    value, ok, err := mdb.GetForecast(m.App_uuid, m.Name, m.Aggregation, req.Time)
+    if err != nil {
+        log.Println("Error occurs when getting forecast:", err)
+    }
+    if ok {
+        m.Value, m.Samples = value, 1
+    }
+
+    m_json, err := json.Marshal(m)
+    if err != nil {
+        log.Println("Error occurs when encoding forecast:", err)
+        return
+    }
+    natsc.Publish(msg.Reply, m_json)
+}
+
+func init() {
+    cfgPtr := flag.String("config", "config/forecaster.json", "Path to the config file")
+    flag.Parse()
+
+    f, err := os.Open(*cfgPtr)
+    if err != nil {
+        fmt.Println("Cannot open the config file:", err)
+        os.Exit(1)
+    }
+
+    err = json.NewDecoder(f).Decode(&cfg)
+    if err != nil {
+        fmt.Println("Cannot decode the config file:", err)
+        os.Exit(1)
+    }
+
+    if cfg.Metrics != "" {
+        err = registry.Load(cfg.Metrics)
+        if err != nil {
+            fmt.Println("Cannot load the metric registry:", err)
+            os.Exit(1)
+        }
+    }
+
+    // The history is read from MetricDB and its rollups, samples kept in InfluxDB would never be seen
+    if cfg.Backend != "" && cfg.Backend != "mysql" {
+        fmt.Println("Cannot forecast from the metric backend:", cfg.Backend)
+        os.Exit(1)
+    }
+
+    pdb_dsn := cfg.PolicyDB["Username"]+":"+cfg.PolicyDB["Password"]+"@tcp("+cfg.PolicyDB["Host"]+":"+cfg.PolicyDB["Port"]+")/"+cfg.PolicyDB["Database"]
+    pdb, err = sql.Open("mysql", pdb_dsn)
+    if err != nil {
+        fmt.Println("Cannot connect to the Policy database:", err)
+        os.Exit(1)
+    }
+
+    mdb_dsn := cfg.MetricDB["Username"]+":"+cfg.MetricDB["Password"]+"@tcp("+cfg.MetricDB["Host"]+":"+cfg.MetricDB["Port"]+")/"+cfg.MetricDB["Database"]
+    mdb_conn, err := sql.Open("mysql", mdb_dsn)
+    if err != nil {
+        fmt.Println("Cannot connect to the Metric database:", err)
+        os.Exit(1)
+    }
+    mdb = MetricDB{db: mdb_conn}
+
+    if cfg.Duration == 0 {
+        cfg.Duration = 3600
+    }
+    if cfg.Step == 0 {
+        cfg.Step = 900
+    }
+    if cfg.Season == 0 {
+        cfg.Season = 604800
+    }
+    if cfg.History == 0 {
+        cfg.History = 4 * cfg.Season
+    }
+    if cfg.Horizon == 0 {
+        cfg.Horizon = 86400
+    }
+    if cfg.Season % cfg.Step != 0 || cfg.History < 2 * cfg.Season {
+        fmt.Println("Season must be a multiple of Step, and History at least two seasons")
+        os.Exit(1)
+    }
+
+    natsc, err = nats.Connect(cfg.Nats)
+    if err != nil {
+        fmt.Println("Cannot connect to the gnatsd:", err)
+        os.Exit(1)
+    }
+
+    if cfg.Log != "" {
+        logf, err := os.OpenFile(cfg.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+        if err != nil {
+            fmt.Println("Cannot open the log file:", err)
+            os.Exit(1)
+        }
+        log.SetOutput(logf)
+    }
+}
+
+func main() {
+    defer pdb.Close()
+    defer mdb.db.Close()
+
+    natsc.Subscribe("forecast", HandleForecast)
+
+    Run()
+    ticker := time.NewTicker(time.Duration(cfg.Duration) * time.Second)
+    for range ticker.C {
+        Run()
+    }
+}
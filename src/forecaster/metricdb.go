@@ -0,0 +1,147 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "math"
+
+    "registry"
+    "scaling"
+)
+
+type MetricDB struct {
+    db *sql.DB
+}
+
+// Forecast is a predicted bucket of a metric of an app
+type Forecast struct {
+    Time int // start of the bucket
+    Value float64
+}
+
+// LoadSeries returns the metric of an app over [start, end) in buckets of step
+// seconds, reduced with the aggregation of the metric, NaN where a bucket has no
+// sample. Collector metrics come from the 1-minute rollups, so the min and max
+// of a bucket are those of the rollups and its p95 and last are computed over
+// their averages; other metrics come from named_metrics.
+func (mdb *MetricDB) LoadSeries(app_uuid string, metric registry.Metric, start int, end int, step int) ([]float64, error) {
+    series := make([]float64, (end - start) / step)
+    for i := range series {
+        series[i] = math.NaN()
+    }
+
+    table, time_col, column := "named_metrics", "created_at", "value"
+    var value string
+    if metric.Source == registry.SourceCollector {
+        table, time_col, column = "metrics_1m", "bucket", metric.Name + "_avg"
+        switch metric.Aggregation {
+            case registry.AggAvg:
+                value = "SUM(" + metric.Name + "_avg * count) / SUM(IF(" + metric.Name + "_avg IS NULL, 0, count))"
+            case registry.AggMin:
+                value = "MIN(" + metric.Name + "_min)"
+            case registry.AggMax:
+                value = "MAX(" + metric.Name + "_max)"
+        }
+    } else {
+        switch metric.Aggregation {
+            case registry.AggAvg, registry.AggMin, registry.AggMax:
+                value = metric.Aggregation + "(value)"
+        }
+    }
+
+    bucket := "FLOOR((" + time_col + " - ?) / ?)"
+    args := []interface{}{start, step, app_uuid, start, end}
+    where := " WHERE app_uuid = ? AND " + time_col + " >= ? AND " + time_col + " < ?"
+    if table == "named_metrics" {
+        where = where + " AND name = ?"
+        args = append(args, metric.Name)
+    }
+
+    if value != "" { // Reduced by the database
+        rows, err := mdb.db.Query("SELECT " + bucket + " AS b, " + value + " FROM " + table + where + " GROUP BY b ORDER BY b", args...)
+        if err != nil {
+            log.Println("Error occurs when querying against metric database:", err)
+            return nil, err
+        }
+        defer rows.Close()
+
+        for rows.Next() {
+            var b int
+            var v sql.NullFloat64
+            if err := rows.Scan(&b, &v); err != nil {
+                log.Println("Error occurs when parsing row:", err)
+                return nil, err
+            }
+            if v.Valid && b >= 0 && b < len(series) {
+                series[b] = v.Float64
+            }
+        }
+        return series, rows.Err()
+    }
+
+    // Reduced here, as the avger would
+    rows, err := mdb.db.Query("SELECT " + bucket + " AS b, " + time_col + ", " + column + " FROM " + table + where + " AND " + column + " IS NOT NULL ORDER BY " + time_col, args...)
+    if err != nil {
+        log.Println("Error occurs when querying against metric database:", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    samples := make(map[int][]scaling.Sample)
+    for rows.Next() {
+        var b int
+        var s scaling.Sample
+        if err := rows.Scan(&b, &s.Time, &s.Value); err != nil {
+            log.Println("Error occurs when parsing row:", err)
+            return nil, err
+        }
+        samples[b] = append(samples[b], s)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    for b, ss := range samples {
+        if b >= 0 && b < len(series) {
+            series[b], _ = scaling.Aggregate(ss, start + b * step, start + (b + 1) * step, metric.Aggregation)
+        }
+    }
+    return series, nil
+}
+
+// StoreForecasts replaces the forecasts of the metric of an app in their buckets.
+func (mdb *MetricDB) StoreForecasts(app_uuid string, metric registry.Metric, step int, forecasts []Forecast, now int) error {
+    for _, f := range forecasts {
+        _, err := mdb.db.Exec("INSERT INTO forecasts(app_uuid, name, aggregation, bucket, step, value, created_at) VALUES (?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE step = VALUES(step), value = VALUES(value), created_at = VALUES(created_at)", app_uuid, metric.Name, metric.Aggregation, f.Time, step, f.Value, now)
+        if err != nil {
+            log.Println("Error occurs when storing forecast:", err)
+            return err
+        }
+    }
+    return nil
+}
+
+// GetForecast returns the forecast of the metric of an app whose bucket holds
+// the unix time at, ok is false when there is none.
+func (mdb *MetricDB) GetForecast(app_uuid string, name string, aggregation string, at int) (value float64, ok bool, err error) {
+    err = mdb.db.QueryRow("SELECT value FROM forecasts WHERE app_uuid = ? AND name = ? AND aggregation = ? AND bucket <= ? AND bucket + step > ? ORDER BY bucket DESC LIMIT 1", app_uuid, name, aggregation, at, at).Scan(&value)
+    if err == sql.ErrNoRows {
+        return 0, false, nil
+    }
+    if err != nil {
+        return 0, false, err
+    }
+    return value, true, nil
+}
+
+// PurgeForecasts deletes the forecasts of buckets older than before.
+func (mdb *MetricDB) PurgeForecasts(before int) {
+    res, err := mdb.db.Exec("DELETE FROM forecasts WHERE bucket < ?", before)
+    if err != nil {
+        log.Println("Error occurs when purging forecasts:", err)
+        return
+    }
+    n, _ := res.RowsAffected()
+    if n > 0 {
+        log.Println("Purged", n, "forecasts older than", before)
+    }
+}
@@ -0,0 +1,128 @@
+// Package influx writes samples to an InfluxDB compatible database and queries
+// them back, in the layout: measurement "metrics" with fields cpu, mem,
+// mem_pct and disk, and measurement "named_metrics" with field value, both
+// tagged by app_uuid and instance_uuid, the latter also by name.
+// It is shared by the monitor, the scraper and the api, which select it
+// over MySQL with the same Backend and InfluxDB configuration.
+package influx
+
+import (
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Backends of the metric store
+const (
+    BackendMySQL = "mysql" // default, the metrics and named_metrics tables of MetricDB
+    BackendInfluxDB = "influxdb"
+)
+
+// Selected tells whether backend is InfluxDB, checking its configuration,
+// or else MySQL. Other backends are unknown.
+func Selected(backend string, c map[string]string) (bool, error) {
+    switch backend {
+        case "", BackendMySQL:
+            return false, nil
+        case BackendInfluxDB:
+            if c["Url"] == "" || c["Database"] == "" {
+                return false, errors.New("InfluxDB needs Url and Database")
+            }
+            return true, nil
+    }
+    return false, errors.New("Unknown metric backend: " + backend)
+}
+
+// Client talks to the /write and /query endpoints of an InfluxDB.
+type Client struct {
+    Url string // e.g. http://localhost:8086
+    Database string
+    Username string
+    Password string
+    HTTP *http.Client
+}
+
+// NewClient returns a client of the configuration c, with keys Url, Database,
+// Username and Password, over the http client, a 10 seconds timeout if nil.
+func NewClient(c map[string]string, client *http.Client) *Client {
+    if client == nil {
+        client = &http.Client{Timeout: 10 * time.Second}
+    }
+    return &Client{
+        Url: strings.TrimRight(c["Url"], "/"),
+        Database: c["Database"],
+        Username: c["Username"],
+        Password: c["Password"],
+        HTTP: client}
+}
+
+// Request returns a request of the endpoint path of the database, such as
+// "/query", with the params and the credentials.
+func (c *Client) Request(method string, path string, params url.Values, body []byte) (*http.Request, error) {
+    if params == nil {
+        params = url.Values{}
+    }
+    params.Set("db", c.Database)
+
+    req, err := http.NewRequest(method, c.Url + path + "?" + params.Encode(), bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    if c.Username != "" {
+        req.SetBasicAuth(c.Username, c.Password)
+    }
+    return req, nil
+}
+
+// Write writes points of the line protocol, timed in seconds, see Line.
+func (c *Client) Write(lines []byte) error {
+    params := url.Values{}
+    params.Set("precision", "s")
+    req, err := c.Request("POST", "/write", params, lines)
+    if err != nil {
+        return err
+    }
+    req.Header.Add("Content-Type", "text/plain; charset=utf-8")
+
+    resp, err := c.HTTP.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+        body, _ := ioutil.ReadAll(resp.Body)
+        return errors.New("InfluxDB responded " + resp.Status + ": " + string(body))
+    }
+    return nil
+}
+
+// Line formats a point of the line protocol, without the tags instance_uuid
+// and name when empty. fields are formatted already, see Field.
+func Line(measurement string, app_uuid string, instance_uuid string, name string, fields string, created_at int) string {
+    line := measurement + ",app_uuid=" + EscapeTag(app_uuid)
+    if instance_uuid != "" {
+        line = line + ",instance_uuid=" + EscapeTag(instance_uuid)
+    }
+    if name != "" {
+        line = line + ",name=" + EscapeTag(name)
+    }
+    return line + " " + fields + " " + strconv.Itoa(created_at) + "\n"
+}
+
+// Field formats a float field of a point.
+func Field(key string, value float64) string {
+    return key + "=" + strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// EscapeTag escapes a tag value of the line protocol.
+func EscapeTag(v string) string {
+    return tagEscaper.Replace(v)
+}
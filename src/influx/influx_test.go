@@ -0,0 +1,74 @@
+package influx
+
+import (
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestWrite(t *testing.T) {
+    var method, path, db, precision, user, password, body string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        method, path = r.Method, r.URL.Path
+        db, precision = r.URL.Query().Get("db"), r.URL.Query().Get("precision")
+        user, password, _ = r.BasicAuth()
+        b, _ := ioutil.ReadAll(r.Body)
+        body = string(b)
+        w.WriteHeader(http.StatusNoContent)
+    }))
+    defer server.Close()
+
+    c := NewClient(map[string]string{"Url": server.URL + "/", "Database": "autoscaler", "Username": "u", "Password": "p"}, nil)
+    line := Line("named_metrics", "app 1", "", "queue,ready", Field("value", 1.5), 60)
+    if err := c.Write([]byte(line)); err != nil {
+        t.Fatal(err)
+    }
+    if method != "POST" || path != "/write" || db != "autoscaler" || precision != "s" || user != "u" || password != "p" {
+        t.Errorf("request = %s %s db=%s precision=%s auth=%s:%s, want POST /write db=autoscaler precision=s auth=u:p", method, path, db, precision, user, password)
+    }
+    if want := "named_metrics,app_uuid=app\\ 1,name=queue\\,ready value=1.5 60\n"; body != want {
+        t.Errorf("body = %q, want %q", body, want)
+    }
+}
+
+func TestWriteRejected(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "unable to parse", http.StatusBadRequest)
+    }))
+    defer server.Close()
+
+    c := NewClient(map[string]string{"Url": server.URL, "Database": "autoscaler"}, nil)
+    if err := c.Write([]byte("bad\n")); err == nil {
+        t.Error("Write of a rejected point succeeded")
+    }
+}
+
+func TestLine(t *testing.T) {
+    got := Line("metrics", "a=b", "i 1", "", Field("cpu", 12) + "," + Field("mem", 0.25), 1700000000)
+    if want := "metrics,app_uuid=a\\=b,instance_uuid=i\\ 1 cpu=12,mem=0.25 1700000000\n"; got != want {
+        t.Errorf("Line = %q, want %q", got, want)
+    }
+}
+
+func TestSelected(t *testing.T) {
+    c := map[string]string{"Url": "http://localhost:8086", "Database": "autoscaler"}
+    tests := []struct {
+        backend string
+        c map[string]string
+        selected bool
+        ok bool
+    }{
+        {"", nil, false, true},
+        {BackendMySQL, c, false, true},
+        {BackendInfluxDB, c, true, true},
+        {BackendInfluxDB, map[string]string{"Url": "http://localhost:8086"}, false, false},
+        {"postgres", c, false, false},
+    }
+    for _, tt := range tests {
+        selected, err := Selected(tt.backend, tt.c)
+        if selected != tt.selected || (err == nil) != tt.ok {
+            t.Errorf("Selected(%q, %v) = %v, %v, want %v, ok %v", tt.backend, tt.c, selected, err, tt.selected, tt.ok)
+        }
+    }
+}
@@ -0,0 +1,173 @@
+package influx
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/url"
+    "strconv"
+    "strings"
+
+    "registry"
+)
+
+// Series is a series of the response to a query, its rows timed in seconds.
+type Series struct {
+    Name string
+    Tags map[string]string
+    Columns []string
+    Values [][]interface{}
+}
+
+type response struct {
+    Results []struct {
+        Series []Series
+        Error string
+    }
+    Error string
+}
+
+// Query returns all the series of the response to q.
+func (c *Client) Query(q string) ([]Series, error) {
+    var series []Series
+    err := c.QueryEach(q, func(s Series) error {
+        series = append(series, s)
+        return nil
+    })
+    return series, err
+}
+
+// QueryEach asks for a chunked response, and calls fn with each chunk of series as it is decoded.
+func (c *Client) QueryEach(q string, fn func(Series) error) error {
+    params := url.Values{}
+    params.Set("epoch", "s")
+    params.Set("chunked", "true")
+    params.Set("q", q)
+
+    req, err := c.Request("GET", "/query", params, nil)
+    if err != nil {
+        return err
+    }
+
+    resp, err := c.HTTP.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    decoder := json.NewDecoder(resp.Body)
+    for {
+        var r response
+        err := decoder.Decode(&r)
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return errors.New("InfluxDB responded " + resp.Status)
+        }
+        if r.Error != "" {
+            return errors.New(r.Error)
+        }
+
+        for _, result := range r.Results {
+            if result.Error != "" {
+                return errors.New(result.Error)
+            }
+            for _, s := range result.Series {
+                if err := fn(s); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+}
+
+// Where selects the points of an app in (start, end), like MetricDB does,
+// of one instance unless instance_uuid is empty.
+func Where(app_uuid string, start int, end int, instance_uuid string) string {
+    w := "app_uuid = " + Quote(app_uuid) + " AND time > " + strconv.Itoa(start) + "s AND time < " + strconv.Itoa(end) + "s"
+    if instance_uuid != "" {
+        w = w + " AND instance_uuid = " + Quote(instance_uuid)
+    }
+    return w
+}
+
+// Grouped returns the query of field of an app in buckets of step seconds over
+// [start, end), aligned on start and reduced by agg, an aggregation of the
+// registry but last. Points of named_metrics are those of the name. By
+// instance, the series are grouped by instance_uuid. Empty buckets are null.
+func Grouped(measurement string, field string, name string, agg string, app_uuid string, start int, end int, step int, by_instance bool) string {
+    var value string
+    switch agg {
+        case registry.AggAvg:
+            value = "mean(" + field + ")"
+        case registry.AggMin, registry.AggMax:
+            value = agg + "(" + field + ")"
+        case registry.AggP95:
+            value = "percentile(" + field + ", 95)"
+    }
+
+    q := "SELECT " + value + " FROM " + measurement + " WHERE " + Where(app_uuid, start - 1, end, "")
+    if name != "" {
+        q = q + " AND name = " + Quote(name)
+    }
+    q = q + " GROUP BY time(" + strconv.Itoa(step) + "s, " + strconv.Itoa(start % step) + "s)"
+    if by_instance {
+        q = q + ", instance_uuid"
+    }
+    return q + " fill(null)"
+}
+
+// Buckets spreads the values of the series of a Grouped query into the num
+// buckets of step seconds from start, by instance_uuid, empty if not grouped
+// by instance. Null buckets are left out.
+func Buckets(series []Series, start int, step int, num int) map[string]map[int][]float64 {
+    values := make(map[string]map[int][]float64) // instance_uuid -> bucket -> values
+    for _, s := range series {
+        instance_uuid := s.Tags["instance_uuid"]
+        if _, exist := values[instance_uuid]; exist == false {
+            values[instance_uuid] = make(map[int][]float64)
+        }
+        for _, row := range s.Values {
+            if len(row) < 2 || row[1] == nil {
+                continue // null bucket
+            }
+            b := (int(FloatOf(row[0])) - start) / step
+            if b < 0 || b >= num {
+                continue
+            }
+            values[instance_uuid][b] = append(values[instance_uuid][b], FloatOf(row[1]))
+        }
+    }
+    return values
+}
+
+// Columns returns the values of a row by column.
+func Columns(columns []string, row []interface{}) map[string]interface{} {
+    v := make(map[string]interface{})
+    for i, c := range columns {
+        if i < len(row) {
+            v[c] = row[i]
+        }
+    }
+    return v
+}
+
+// FloatOf returns a number of a row, 0 if null.
+func FloatOf(v interface{}) float64 {
+    f, _ := v.(float64)
+    return f
+}
+
+// StringOf returns a string of a row, empty if null.
+func StringOf(v interface{}) string {
+    s, _ := v.(string)
+    return s
+}
+
+var stringEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// Quote quotes a string literal of InfluxQL.
+func Quote(s string) string {
+    return "'" + stringEscaper.Replace(s) + "'"
+}
@@ -0,0 +1,76 @@
+package influx
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "registry"
+)
+
+func TestGrouped(t *testing.T) {
+    tests := []struct {
+        name string
+        q string
+        want string
+    }{
+        {"collector by instance", Grouped("metrics", "cpu", "", registry.AggMax, "app1", 1000, 1180, 60, true),
+            "SELECT max(cpu) FROM metrics WHERE app_uuid = 'app1' AND time > 999s AND time < 1180s GROUP BY time(60s, 40s), instance_uuid fill(null)"},
+        {"named p95", Grouped("named_metrics", "value", "latency", registry.AggP95, "app1", 1200, 1500, 300, false),
+            "SELECT percentile(value, 95) FROM named_metrics WHERE app_uuid = 'app1' AND time > 1199s AND time < 1500s AND name = 'latency' GROUP BY time(300s, 0s) fill(null)"},
+        {"avg quoted", Grouped("named_metrics", "value", "it's", registry.AggAvg, "app1", 0, 60, 60, false),
+            "SELECT mean(value) FROM named_metrics WHERE app_uuid = 'app1' AND time > -1s AND time < 60s AND name = 'it\\'s' GROUP BY time(60s, 0s) fill(null)"},
+    }
+    for _, tt := range tests {
+        if tt.q != tt.want {
+            t.Errorf("%s: Grouped = %q, want %q", tt.name, tt.q, tt.want)
+        }
+    }
+}
+
+func TestQueryBuckets(t *testing.T) {
+    var path, db, epoch, q string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        path, db, epoch, q = r.URL.Path, r.URL.Query().Get("db"), r.URL.Query().Get("epoch"), r.URL.Query().Get("q")
+        // Two chunks, as a chunked response streams them
+        w.Write([]byte(`{"results":[{"series":[{"name":"metrics","tags":{"instance_uuid":"i1"},"columns":["time","max"],"values":[[1000,40],[1060,null],[1120,90]]}]}]}` + "\n"))
+        w.Write([]byte(`{"results":[{"series":[{"name":"metrics","tags":{"instance_uuid":"i2"},"columns":["time","max"],"values":[[940,10],[1000,20],[1060,30],[1180,50]]}]}]}` + "\n"))
+    }))
+    defer server.Close()
+
+    c := NewClient(map[string]string{"Url": server.URL, "Database": "autoscaler"}, nil)
+    series, err := c.Query("SELECT max(cpu) FROM metrics")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if path != "/query" || db != "autoscaler" || epoch != "s" || q != "SELECT max(cpu) FROM metrics" {
+        t.Errorf("request = %s db=%s epoch=%s q=%q", path, db, epoch, q)
+    }
+
+    values := Buckets(series, 1000, 60, 3)
+    wants := map[string]map[int]float64{"i1": {0: 40, 2: 90}, "i2": {0: 20, 1: 30}}
+    for instance_uuid, want := range wants {
+        got := values[instance_uuid]
+        if len(got) != len(want) {
+            t.Errorf("buckets of %s = %v, want %v", instance_uuid, got, want)
+            continue
+        }
+        for b, v := range want {
+            if len(got[b]) != 1 || got[b][0] != v {
+                t.Errorf("bucket %d of %s = %v, want %v", b, instance_uuid, got[b], v)
+            }
+        }
+    }
+}
+
+func TestQueryError(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`{"results":[{"error":"database not found: autoscaler"}]}` + "\n"))
+    }))
+    defer server.Close()
+
+    c := NewClient(map[string]string{"Url": server.URL, "Database": "autoscaler"}, nil)
+    if _, err := c.Query("SELECT value FROM named_metrics"); err == nil || err.Error() != "database not found: autoscaler" {
+        t.Errorf("err = %v, want the error of the result", err)
+    }
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fields of a gorouter access log line we care about, e.g.
+// app.example.com - [2016-05-10T08:39:44.812+0000] "GET / HTTP/1.1" 200 0 12 "-" "curl/7.35.0" 10.0.0.1:51234 10.0.16.5:61012 x_forwarded_for:"-" x_forwarded_proto:"http" vcap_request_id:8e2a... response_time:0.003415645 app_id:6c2c...
+var (
+	responseTimeRegexp = regexp.MustCompile(`response_time:"?([0-9.]+)"?`)
+	appIdRegexp        = regexp.MustCompile(`app_id:"?([0-9a-fA-F-]+)"?`)
+)
+
+// An app which had requests keeps reporting throughput=0 on idle intervals for
+// that long, in seconds, so that averages cover its idle time and policies of
+// an idle app still see data to scale in on.
+const IDLE_REPORT = 24 * 3600
+
+type httpStats struct {
+	requests  int
+	latencies []float64 // milliseconds
+}
+
+// AccessLog aggregates gorouter access log lines into per-app throughput (requests/second)
+// and 95th percentile latency (milliseconds), flushed every interval. Apps seen
+// recently without requests during an interval get a throughput of 0 and no latency.
+type AccessLog struct {
+	sync.Mutex
+	interval  int // seconds
+	stats     map[string]*httpStats
+	seen      map[string]int32 // unix time of the last request of each app
+	avgerConn net.Conn
+	store     MetricStore
+}
+
+func NewAccessLog(interval int, avgerConn net.Conn, store MetricStore) *AccessLog {
+	return &AccessLog{
+		interval:  interval,
+		stats:     make(map[string]*httpStats),
+		seen:      make(map[string]int32),
+		avgerConn: avgerConn,
+		store:     store,
+	}
+}
+
+func (al *AccessLog) handleLine(line string) {
+	app_id := appIdRegexp.FindStringSubmatch(line)
+	response_time := responseTimeRegexp.FindStringSubmatch(line)
+	if app_id == nil || response_time == nil {
+		return // Not an access log line of an app
+	}
+
+	seconds, err := strconv.ParseFloat(response_time[1], 64)
+	if err != nil {
+		log.Println("Cannot parse the response time:", response_time[1])
+		return
+	}
+
+	al.Lock()
+	defer al.Unlock()
+
+	s, exist := al.stats[app_id[1]]
+	if exist == false {
+		s = &httpStats{}
+		al.stats[app_id[1]] = s
+	}
+	s.requests = s.requests + 1
+	s.latencies = append(s.latencies, seconds*1000)
+}
+
+// Run flushes the aggregated stats every interval, it never returns.
+func (al *AccessLog) Run() {
+	ticker := time.NewTicker(time.Duration(al.interval) * time.Second)
+	for range ticker.C {
+		al.flush()
+	}
+}
+
+func (al *AccessLog) flush() {
+	now := int32(time.Now().Unix())
+
+	al.Lock()
+	stats := al.stats
+	al.stats = make(map[string]*httpStats)
+	for app_uuid := range stats {
+		al.seen[app_uuid] = now
+	}
+	for app_uuid, last := range al.seen {
+		if now-last > IDLE_REPORT {
+			delete(al.seen, app_uuid)
+		} else if _, exist := stats[app_uuid]; exist == false {
+			stats[app_uuid] = &httpStats{} // Idle during this interval
+		}
+	}
+	al.Unlock()
+
+	for app_uuid, s := range stats {
+		throughput := float64(s.requests) / float64(al.interval)
+		message := app_uuid + " - throughput=" + strconv.FormatFloat(throughput, 'f', -1, 64)
+
+		err := al.store.AddNamed(app_uuid, "", "throughput", throughput, now)
+		if err == nil && s.requests > 0 { // No latency without requests
+			latency := percentile(s.latencies, 0.95)
+			err = al.store.AddNamed(app_uuid, "", "latency", latency, now)
+			message = message + " latency=" + strconv.FormatFloat(latency, 'f', -1, 64)
+		}
+		if err != nil {
+			log.Println("Cannot insert to the database:", err)
+		}
+		log.Println("Saved: ", message)
+
+		al.avgerConn.Write([]byte(message + "\n"))
+	}
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of values using the nearest-rank method.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	rank := int(math.Ceil(p*float64(len(values)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return values[rank]
+}
+
+// ListenSyslog accepts syslog drain connections on port and feeds their messages to the aggregator.
+func (al *AccessLog) ListenSyslog(port string) {
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal("Cannot listen on the port:", port, err)
+	}
+	defer l.Close()
+	log.Println("Listening to syslog drain on 0.0.0.0, port", port)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Println("Cannot accept the syslog connection", err)
+			continue
+		}
+
+		go func(c net.Conn) {
+			defer c.Close()
+			scanner := bufio.NewScanner(c)
+			scanner.Split(scanSyslog)
+			for scanner.Scan() {
+				al.handleLine(scanner.Text())
+			}
+			if err := scanner.Err(); err != nil {
+				log.Println("Cannot read the syslog input:", err)
+			}
+		}(conn)
+	}
+}
+
+// scanSyslog splits syslog messages framed either by octet counting ("LEN MSG", RFC 6587)
+// or by newlines.
+func scanSyslog(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if sp := bytes.IndexByte(data, ' '); sp > 0 {
+		if n, err := strconv.Atoi(string(data[:sp])); err == nil {
+			if len(data) < sp+1+n {
+				if atEOF {
+					return len(data), data[sp+1:], nil
+				}
+				return 0, nil, nil // Request more data
+			}
+			return sp + 1 + n, bytes.TrimRight(data[sp+1:sp+1+n], "\n"), nil
+		}
+	}
+	return bufio.ScanLines(data, atEOF)
+}
+
+// TailFile follows path like "tail -f" and feeds the new lines to the aggregator.
+// It stands in for a syslog drain when the access log is written to a file.
+func (al *AccessLog) TailFile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal("Cannot open the access log file:", err)
+	}
+	defer f.Close()
+
+	_, err = f.Seek(0, io.SeekEnd)
+	if err != nil {
+		log.Fatal("Cannot seek the access log file:", err)
+	}
+	log.Println("Tailing the access log file", path)
+
+	reader := bufio.NewReader(f)
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			partial = partial + line
+			time.Sleep(time.Second)
+			continue
+		}
+		if err != nil {
+			log.Println("Cannot read the access log file:", err)
+			return
+		}
+		al.handleLine(strings.TrimRight(partial+line, "\r\n"))
+		partial = ""
+	}
+}
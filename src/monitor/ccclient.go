@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CCClient struct {
+	api_host  string
+	auth_host string
+	auth_user string
+	auth_pass string
+}
+
+type Token struct {
+	Access_token  string
+	Token_type    string
+	Refresh_token string
+	Expires_in    int
+	Scope         string
+	Jti           string
+}
+
+// GetMemoryQuota returns the memory limit of each instance of the app, in MB.
+func (c *CCClient) GetMemoryQuota(app_uuid string) (int, error) {
+	API_URI := strings.Join([]string{"http://", c.api_host, "/v2/apps/", app_uuid, "/summary"}, "")
+
+	req, err := http.NewRequest("GET", API_URI, nil)
+	if err != nil {
+		log.Println("Cannot create GET request to the API host.")
+		return 0, err
+	}
+	token, err := c.getToken()
+	if err != nil {
+		log.Println("Cannot get token.")
+		return 0, err
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Accept", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("Request to the API host failed.")
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("API host responded " + resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println("Cannot read the response from API server.")
+		return 0, err
+	}
+
+	var app struct {
+		Memory int
+	}
+	err = json.Unmarshal(body, &app)
+	if err != nil {
+		log.Println("Cannot decode JSON message from API server.")
+		return 0, err
+	}
+	if app.Memory <= 0 {
+		return 0, errors.New("No memory quota for app " + app_uuid)
+	}
+
+	return app.Memory, nil
+}
+
+func (c *CCClient) getToken() (token string, err error) {
+	// TODO: Use refresh_token
+	auth_URI := strings.Join([]string{"http://", c.auth_host, "/oauth/token"}, "")
+	data := strings.Join([]string{"grant_type=password&username=", c.auth_user, "&password=", c.auth_pass}, "")
+	req, err := http.NewRequest("POST", auth_URI, strings.NewReader(data))
+	if err != nil {
+		log.Println("Cannot create POST request to the authentication server.")
+		return "", err
+	}
+
+	req.Header.Add("Authorization", "Basic Y2Y6")
+	req.Header.Add("Accept", "application/json, application/x-www-form-urlencoded")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("Request to the authentication failed.")
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println("Cannot read response from the authentication server.")
+		return "", err
+	}
+	var t Token
+	err = json.Unmarshal(body, &t)
+	if err != nil {
+		log.Println("Decode JSON failed.")
+		return "", err
+	}
+	return t.Access_token, nil
+}
+
+// QUOTA_RETRY is how long, in seconds, the Cloud Controller is not asked
+// again for the quota of an app after it failed to answer.
+const QUOTA_RETRY = 60
+
+type quotaEntry struct {
+	mb         int
+	known      bool  // mb was fetched once
+	fetched_at int64 // of mb
+	failed_at  int64 // of the last failure, 0 if the last fetch succeeded
+	refreshing bool
+}
+
+// QuotaCache keeps the memory quota of apps for ttl seconds, so that the
+// Cloud Controller is not asked for every metric line. Quotas are fetched in
+// the background, metric lines never wait for the Cloud Controller.
+type QuotaCache struct {
+	sync.Mutex
+	ccc     *CCClient
+	ttl     int64
+	entries map[string]*quotaEntry
+}
+
+func NewQuotaCache(ccc *CCClient, ttl int) *QuotaCache {
+	return &QuotaCache{ccc: ccc, ttl: int64(ttl), entries: make(map[string]*quotaEntry)}
+}
+
+// Get returns the memory quota of the app in bytes, ok is false while it is
+// not known yet. A quota older than ttl is refreshed in the background and
+// returned meanwhile, or for good when the Cloud Controller cannot be
+// reached, which is retried after QUOTA_RETRY seconds.
+func (qc *QuotaCache) Get(app_uuid string) (quota float64, ok bool) {
+	now := time.Now().Unix()
+
+	qc.Lock()
+	defer qc.Unlock()
+	e, exist := qc.entries[app_uuid]
+	if exist == false {
+		e = &quotaEntry{}
+		qc.entries[app_uuid] = e
+	}
+	stale := e.known == false || now-e.fetched_at >= qc.ttl
+	if stale && e.refreshing == false && now-e.failed_at >= QUOTA_RETRY {
+		e.refreshing = true
+		go qc.refresh(app_uuid)
+	}
+	return float64(e.mb) * 1024 * 1024, e.known
+}
+
+func (qc *QuotaCache) refresh(app_uuid string) {
+	mb, err := qc.ccc.GetMemoryQuota(app_uuid)
+	now := time.Now().Unix()
+
+	qc.Lock()
+	defer qc.Unlock()
+	e := qc.entries[app_uuid]
+	e.refreshing = false
+	if err != nil {
+		log.Println("Cannot get the memory quota of", app_uuid, err)
+		e.failed_at = now
+		return
+	}
+	e.mb, e.known, e.fetched_at, e.failed_at = mb, true, now, 0
+}
@@ -17,8 +17,9 @@ import (
 )
 
 type Metric struct {
-	Cpu float64 `json:"cpu"`
-	Mem float64 `json:"mem"`
+	Cpu  float64 `json:"cpu"`
+	Mem  float64 `json:"mem"`
+	Disk float64 `json:"disk"`
 }
 
 type MetricRow struct {
@@ -27,6 +28,7 @@ type MetricRow struct {
 	Created_at    int
 	Cpu           float64
 	Mem           float64
+	Disk          float64
 }
 
 type Configuration struct {
@@ -36,19 +38,38 @@ type Configuration struct {
 	Password string
 	AvgerHost string
 	AvgerPort string
+
+	// Unit of the mem reported by the collector: B, KB or MB
+	MemUnit string
+
+	// Memory quotas of the apps, for mem_pct
+	CloudController map[string]string
+	QuotaTTL        int // seconds
+
+	// Router access logs, from a syslog drain and/or a file
+	AccessLogPort     string
+	AccessLogFile     string
+	AccessLogInterval int // seconds
+
+	// Where samples are saved: "mysql" (default, the Database above) or "influxdb"
+	Backend  string
+	InfluxDB map[string]string // Url, Database, Username, Password
 }
 
-func handleConnection(avgerConn net.Conn, c net.Conn, db *sql.DB) {
+// Bytes per unit of the mem reported by the collector
+var memBytesPerUnit float64 = 1
+
+func handleConnection(avgerConn net.Conn, c net.Conn, store MetricStore, quotas *QuotaCache) {
 	scanner := bufio.NewScanner(c)
 	for scanner.Scan() {
-		handleLine(avgerConn, scanner.Text(), db)
+		handleLine(avgerConn, scanner.Text(), store, quotas)
 	}
 	if err := scanner.Err(); err != nil {
 		log.Println("Cannot read the connection input:", err)
 	}
 }
 
-func handleLine(avgerConn net.Conn, line string, db *sql.DB) {
+func handleLine(avgerConn net.Conn, line string, store MetricStore, quotas *QuotaCache) {
 	// As TSDB protocol, line has format:
 	// "put key timestamp value tags\n"
 	// tags: "#{key}=#{v}"
@@ -64,13 +85,27 @@ func handleLine(avgerConn net.Conn, line string, db *sql.DB) {
 
 		for app_uuid, instances := range apps {
 			for instance_uuid, metric := range instances {
-				_, err := db.Exec("INSERT INTO metrics (app_uuid, instance_uuid, created_at, cpu, mem) VALUES (?, ?, ?, ?, ?);", app_uuid, instance_uuid, int32(time.Now().Unix()), metric.Cpu, metric.Mem)
+				metric.Mem = metric.Mem * memBytesPerUnit
+
+				// Memory as percentage of the quota, NULL when the quota is unknown
+				var mem_pct sql.NullFloat64
+				if quotas != nil {
+					if quota, ok := quotas.Get(app_uuid); ok {
+						mem_pct = sql.NullFloat64{Float64: metric.Mem / quota * 100, Valid: true}
+					}
+				}
+
+				err := store.AddMetric(app_uuid, instance_uuid, int32(time.Now().Unix()), metric.Cpu, metric.Mem, mem_pct, metric.Disk)
 				if err != nil {
 					log.Println("Cannot insert to the database:", err)
 				}
-				log.Println("Saved: ", app_uuid, instance_uuid, "cpu: ", metric.Cpu, "mem: ", metric.Mem)
+				log.Println("Saved: ", app_uuid, instance_uuid, "cpu: ", metric.Cpu, "mem: ", metric.Mem, "mem_pct: ", mem_pct.Float64, "disk: ", metric.Disk)
 
-				message := app_uuid + " " + instance_uuid + " " + strconv.FormatFloat(metric.Cpu, 'f', -1, 64) + " " + strconv.FormatFloat(metric.Mem, 'f', -1, 64) + "\n"
+				message := app_uuid + " " + instance_uuid + " cpu=" + strconv.FormatFloat(metric.Cpu, 'f', -1, 64) + " mem=" + strconv.FormatFloat(metric.Mem, 'f', -1, 64) + " disk=" + strconv.FormatFloat(metric.Disk, 'f', -1, 64)
+				if mem_pct.Valid {
+					message = message + " mem_pct=" + strconv.FormatFloat(mem_pct.Float64, 'f', -1, 64)
+				}
+				message = message + "\n"
 				log.Println(message)
 				avgerConn.Write([]byte(message))
 			}
@@ -100,6 +135,35 @@ func main() {
 	}
 	defer db.Close()
 
+	store, err := NewMetricStore(cfg.Backend, db, cfg.InfluxDB)
+	if err != nil {
+		log.Fatal("Cannot set up the metric backend: ", err)
+	}
+
+	switch cfg.MemUnit {
+	case "", "B":
+		memBytesPerUnit = 1
+	case "KB":
+		memBytesPerUnit = 1024
+	case "MB":
+		memBytesPerUnit = 1024 * 1024
+	default:
+		log.Fatal("Unknown MemUnit: ", cfg.MemUnit)
+	}
+
+	var quotas *QuotaCache
+	if cfg.CloudController["Api_host"] != "" {
+		if cfg.QuotaTTL == 0 {
+			cfg.QuotaTTL = 300
+		}
+		ccc := CCClient{
+			api_host:  cfg.CloudController["Api_host"],
+			auth_host: cfg.CloudController["Auth_host"],
+			auth_user: cfg.CloudController["Auth_user"],
+			auth_pass: cfg.CloudController["Auth_pass"]}
+		quotas = NewQuotaCache(&ccc, cfg.QuotaTTL)
+	}
+
 	// Listen on TCP port 4567 on all interfaces.
 	l, err := net.Listen("tcp", ":"+cfg.Port)
 	if err != nil {
@@ -114,6 +178,20 @@ func main() {
 	}
 	defer avgerConn.Close()
 
+	if cfg.AccessLogPort != "" || cfg.AccessLogFile != "" {
+		if cfg.AccessLogInterval == 0 {
+			cfg.AccessLogInterval = 10
+		}
+		accessLog := NewAccessLog(cfg.AccessLogInterval, avgerConn, store)
+		go accessLog.Run()
+		if cfg.AccessLogPort != "" {
+			go accessLog.ListenSyslog(cfg.AccessLogPort)
+		}
+		if cfg.AccessLogFile != "" {
+			go accessLog.TailFile(cfg.AccessLogFile)
+		}
+	}
+
 	for {
 		// Wait for a connection.
 		conn, err := l.Accept()
@@ -124,6 +202,6 @@ func main() {
 		// Handle the connection in a new goroutine.
 		// The loop then returns to accepting, so that
 		// multiple connections may be served concurrently.
-		go handleConnection(avgerConn, conn, db)
+		go handleConnection(avgerConn, conn, store, quotas)
 	}
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+
+	"influx"
+)
+
+// MetricStore is where the monitor saves the samples it receives.
+type MetricStore interface {
+	AddMetric(app_uuid string, instance_uuid string, created_at int32, cpu float64, mem float64, mem_pct sql.NullFloat64, disk float64) error
+	AddNamed(app_uuid string, instance_uuid string, name string, value float64, created_at int32) error
+}
+
+// MySQLStore saves samples into the metrics and named_metrics tables of MetricDB.
+type MySQLStore struct {
+	db *sql.DB
+}
+
+func (s *MySQLStore) AddMetric(app_uuid string, instance_uuid string, created_at int32, cpu float64, mem float64, mem_pct sql.NullFloat64, disk float64) error {
+	_, err := s.db.Exec("INSERT INTO metrics (app_uuid, instance_uuid, created_at, cpu, mem, mem_pct, disk) VALUES (?, ?, ?, ?, ?, ?, ?);", app_uuid, instance_uuid, created_at, cpu, mem, mem_pct, disk)
+	return err
+}
+
+func (s *MySQLStore) AddNamed(app_uuid string, instance_uuid string, name string, value float64, created_at int32) error {
+	_, err := s.db.Exec("INSERT INTO named_metrics (app_uuid, instance_uuid, name, value, created_at) VALUES (?, ?, ?, ?, ?);", app_uuid, instance_uuid, name, value, created_at)
+	return err
+}
+
+// InfluxStore writes samples to an InfluxDB, in the layout of the influx package.
+type InfluxStore struct {
+	client *influx.Client
+}
+
+func NewInfluxStore(c map[string]string) *InfluxStore {
+	return &InfluxStore{client: influx.NewClient(c, nil)}
+}
+
+func (s *InfluxStore) AddMetric(app_uuid string, instance_uuid string, created_at int32, cpu float64, mem float64, mem_pct sql.NullFloat64, disk float64) error {
+	fields := influx.Field("cpu", cpu) + "," + influx.Field("mem", mem) + "," + influx.Field("disk", disk)
+	if mem_pct.Valid {
+		fields = fields + "," + influx.Field("mem_pct", mem_pct.Float64)
+	}
+	return s.client.Write([]byte(influx.Line("metrics", app_uuid, instance_uuid, "", fields, int(created_at))))
+}
+
+func (s *InfluxStore) AddNamed(app_uuid string, instance_uuid string, name string, value float64, created_at int32) error {
+	return s.client.Write([]byte(influx.Line("named_metrics", app_uuid, instance_uuid, name, influx.Field("value", value), int(created_at))))
+}
+
+// NewMetricStore returns the store selected by backend: "mysql" (default) or "influxdb".
+func NewMetricStore(backend string, db *sql.DB, c map[string]string) (MetricStore, error) {
+	selected, err := influx.Selected(backend, c)
+	if err != nil {
+		return nil, err
+	}
+	if selected {
+		return NewInfluxStore(c), nil
+	}
+	return &MySQLStore{db: db}, nil
+}
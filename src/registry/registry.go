@@ -0,0 +1,171 @@
+// Package registry describes the metrics policies can refer to.
+// It is shared by the api, director, engine and avger so that a new metric
+// only needs to be registered here, or in the metrics file, to be scalable.
+package registry
+
+import (
+    "encoding/json"
+    "errors"
+    "os"
+    "regexp"
+    "sort"
+    "sync"
+)
+
+// Sources of metrics
+const (
+    SourceCollector = "collector" // CF collector through the monitor, stored in the metrics table
+    SourceRouter = "router" // gorouter access logs through the monitor
+    SourceRabbitMQ = "rabbitmq" // RabbitMQ management API through the scraper
+    SourceApp = "app" // pushed by the app through the api or scraped from its /metrics
+)
+
+// Aggregations of the samples within a measurement period
+const (
+    AggAvg = "avg"
+    AggMin = "min"
+    AggMax = "max"
+    AggLast = "last"
+    AggP95 = "p95" // 95th percentile
+)
+
+type Metric struct {
+    Name string
+    Unit string
+    Min float64 // valid range of a value, unbounded when Max <= Min
+    Max float64
+    Source string
+    Aggregation string // default aggregation, avg if empty
+    Type int // legacy policies.metric_type, -1 if none
+}
+
+var nameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+var lock sync.RWMutex
+var metrics = map[string]Metric{}
+
+func init() {
+    for _, m := range []Metric{
+        {Name: "cpu", Unit: "%", Min: 0, Max: 6400, Source: SourceCollector, Aggregation: AggAvg, Type: 0}, // of a core, up to 64 cores
+        {Name: "mem", Unit: "bytes", Min: 0, Max: 1 << 40, Source: SourceCollector, Aggregation: AggAvg, Type: 1},
+        {Name: "mem_pct", Unit: "% of quota", Min: 0, Max: 200, Source: SourceCollector, Aggregation: AggAvg, Type: -1}, // above 100 while the monitor caches a quota since lowered
+        {Name: "disk", Unit: "bytes", Min: 0, Max: 1 << 40, Source: SourceCollector, Aggregation: AggAvg, Type: -1},
+        {Name: "throughput", Unit: "requests/s", Source: SourceRouter, Aggregation: AggAvg, Type: 2},
+        {Name: "latency", Unit: "ms", Min: 0, Max: 3600000, Source: SourceRouter, Aggregation: AggMax, Type: 3}, // samples are p95s of the monitor, the worst one of a period, not their avg
+        {Name: "queue_ready", Unit: "messages", Source: SourceRabbitMQ, Aggregation: AggLast, Type: -1},
+        {Name: "queue_publish_rate", Unit: "messages/s", Source: SourceRabbitMQ, Aggregation: AggAvg, Type: -1},
+        {Name: "queue_ack_rate", Unit: "messages/s", Source: SourceRabbitMQ, Aggregation: AggAvg, Type: -1},
+    } {
+        metrics[m.Name] = m
+    }
+}
+
+// Register adds m to the registry, or replaces the metric of the same name.
+// Built-in metrics cannot be replaced by app metrics.
+func Register(m Metric) error {
+    if IsValidName(m.Name) == false {
+        return errors.New("Invalid metric name: " + m.Name)
+    }
+    if m.Aggregation == "" {
+        m.Aggregation = AggAvg
+    }
+    if IsValidAggregation(m.Aggregation) == false {
+        return errors.New("Invalid aggregation of " + m.Name + ": " + m.Aggregation)
+    }
+    if m.Source == "" {
+        m.Source = SourceApp
+    }
+
+    lock.Lock()
+    defer lock.Unlock()
+
+    m.Type = -1
+    if old, exist := metrics[m.Name]; exist {
+        if old.Source != m.Source {
+            return errors.New("Metric " + m.Name + " is already registered from " + old.Source)
+        }
+        m.Type = old.Type
+    }
+    metrics[m.Name] = m
+    return nil
+}
+
+// Load registers the metrics listed in the JSON file at path, e.g.
+// [{"Name": "jobs_pending", "Unit": "jobs", "Source": "app", "Aggregation": "max"}]
+func Load(path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    var list []Metric
+    err = json.NewDecoder(f).Decode(&list)
+    if err != nil {
+        return err
+    }
+
+    for _, m := range list {
+        err := Register(m)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func Lookup(name string) (Metric, bool) {
+    lock.RLock()
+    defer lock.RUnlock()
+
+    m, ok := metrics[name]
+    return m, ok
+}
+
+// ByType returns the metric of a legacy policies.metric_type.
+func ByType(t int) (Metric, bool) {
+    lock.RLock()
+    defer lock.RUnlock()
+
+    for _, m := range metrics {
+        if m.Type == t && t >= 0 {
+            return m, true
+        }
+    }
+    return Metric{}, false
+}
+
+// All returns every registered metric sorted by name.
+func All() []Metric {
+    lock.RLock()
+    defer lock.RUnlock()
+
+    var list []Metric
+    for _, m := range metrics {
+        list = append(list, m)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+    return list
+}
+
+// IsValidName checks the syntax of a metric name: lowercase letters, digits
+// and underscores, starting with a letter.
+func IsValidName(name string) bool {
+    return nameRegexp.MatchString(name)
+}
+
+func IsValidAggregation(agg string) bool {
+    switch agg {
+        case AggAvg, AggMin, AggMax, AggLast, AggP95:
+            return true
+    }
+    return false
+}
+
+// InRange tells whether v is a plausible value of the metric.
+func (m Metric) InRange(v float64) bool {
+    if m.Max <= m.Min {
+        return true
+    }
+    return v >= m.Min && v <= m.Max
+}
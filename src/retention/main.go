@@ -0,0 +1,231 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    _ "github.com/go-sql-driver/mysql"
+)
+
+var db *sql.DB
+var cfg Configuration
+var duration int = 60 // seconds
+
+type Configuration struct {
+    MetricDB map[string]string
+    Duration int // seconds between runs
+    Lateness int // seconds a sample may arrive after its time, a bucket is rolled up after it
+    RawRetention int // seconds, rows of metrics older than that are purged
+    MinuteRetention int // seconds, rows of metrics_1m older than that are purged
+    HourRetention int // seconds, rows of metrics_1h older than that are purged, 0 to keep them forever
+    NamedRetention int // seconds, rows of named_metrics older than that are purged, RawRetention if 0; keep them as long as the forecaster's History
+    Log string
+}
+
+// Columns of the metrics table which are rolled up into <column>_avg, <column>_min and <column>_max
+var columns = []string{"cpu", "mem", "mem_pct", "disk"}
+
+// Rollup aggregates the rows of *from* whose bucket is in [start, end) into *to*,
+// by buckets of *width* seconds. *raw* tells whether *from* is the metrics table
+// or another rollup table, whose averages must be weighted by their count.
+func Rollup(from string, to string, width int, raw bool, start int, end int) error {
+    var time_col string
+    var selects []string
+    var updates []string
+    if raw {
+        time_col = "created_at"
+        selects = append(selects, "COUNT(*)")
+        for _, c := range columns {
+            selects = append(selects, "AVG(" + c + ")", "MIN(" + c + ")", "MAX(" + c + ")")
+        }
+    } else {
+        time_col = "bucket"
+        selects = append(selects, "SUM(count)")
+        for _, c := range columns {
+            selects = append(selects,
+                "SUM(" + c + "_avg * count) / SUM(IF(" + c + "_avg IS NULL, 0, count))",
+                "MIN(" + c + "_min)",
+                "MAX(" + c + "_max)")
+        }
+    }
+
+    inserts := []string{"count"}
+    updates = append(updates, "count = VALUES(count)")
+    for _, c := range columns {
+        for _, agg := range []string{"_avg", "_min", "_max"} {
+            inserts = append(inserts, c + agg)
+            updates = append(updates, c + agg + " = VALUES(" + c + agg + ")")
+        }
+    }
+
+    bucket := fmt.Sprintf("FLOOR(%s / %d) * %d", time_col, width, width)
+    q := "INSERT INTO " + to + " (app_uuid, instance_uuid, bucket, " + strings.Join(inserts, ", ") + ") " +
+        "SELECT app_uuid, instance_uuid, " + bucket + ", " + strings.Join(selects, ", ") + " " +
+        "FROM " + from + " WHERE " + time_col + " >= ? AND " + time_col + " < ? " +
+        "GROUP BY app_uuid, instance_uuid, " + bucket + " " +
+        "ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+
+    _, err := db.Exec(q, start, end)
+    if err != nil {
+        log.Println("Error occurs when rolling up", from, "into", to, ":", err)
+        return err
+    }
+    return nil
+}
+
+// RolledUntil returns the time until which the rollup table is complete.
+func RolledUntil(table string) (int, error) {
+    var until int
+    err := db.QueryRow("SELECT rolled_until FROM rollups WHERE table_name = ?", table).Scan(&until)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    }
+    return until, err
+}
+
+func SetRolledUntil(table string, until int) error {
+    _, err := db.Exec("INSERT INTO rollups (table_name, rolled_until) VALUES (?, ?) ON DUPLICATE KEY UPDATE rolled_until = VALUES(rolled_until)", table, until)
+    return err
+}
+
+// Step rolls *from* up into *to* until the last complete bucket before *ready*,
+// and returns the time until which *to* is complete.
+func Step(from string, to string, width int, raw bool, ready int) (int, error) {
+    until, err := RolledUntil(to)
+    if err != nil {
+        log.Println("Error occurs when reading the rollup state of", to, ":", err)
+        return 0, err
+    }
+
+    end := ready / width * width
+    if end <= until {
+        return until, nil
+    }
+
+    start := until
+    if start == 0 { // First run, roll up everything
+        time_col := "bucket"
+        if raw {
+            time_col = "created_at"
+        }
+        err := db.QueryRow("SELECT IFNULL(MIN(" + time_col + "), 0) FROM " + from).Scan(&start)
+        if err != nil {
+            log.Println("Error occurs when finding the oldest row of", from, ":", err)
+            return 0, err
+        }
+        start = start / width * width
+    }
+
+    err = Rollup(from, to, width, raw, start, end)
+    if err != nil {
+        return until, err
+    }
+
+    err = SetRolledUntil(to, end)
+    if err != nil {
+        log.Println("Error occurs when saving the rollup state of", to, ":", err)
+        return until, err
+    }
+    log.Println("Rolled", from, "up into", to, "from", start, "to", end)
+    return end, nil
+}
+
+// Purge deletes the rows of table older than retention seconds, but never the
+// rows which aren't rolled up yet.
+func Purge(table string, time_col string, retention int, rolled_until int, now int) {
+    if retention <= 0 {
+        return
+    }
+
+    before := now - retention
+    if before > rolled_until {
+        before = rolled_until
+    }
+
+    res, err := db.Exec("DELETE FROM " + table + " WHERE " + time_col + " < ?", before)
+    if err != nil {
+        log.Println("Error occurs when purging", table, ":", err)
+        return
+    }
+    n, _ := res.RowsAffected()
+    if n > 0 {
+        log.Println("Purged", n, "rows of", table, "older than", before)
+    }
+}
+
+func Run() {
+    now := int(time.Now().Unix())
+
+    minute_until, err := Step("metrics", "metrics_1m", 60, true, now - cfg.Lateness)
+    if err != nil {
+        return
+    }
+    hour_until, err := Step("metrics_1m", "metrics_1h", 3600, false, minute_until)
+    if err != nil {
+        return
+    }
+
+    Purge("metrics", "created_at", cfg.RawRetention, minute_until, now)
+    Purge("metrics_1m", "bucket", cfg.MinuteRetention, hour_until, now)
+    Purge("metrics_1h", "bucket", cfg.HourRetention, now, now)
+    Purge("named_metrics", "created_at", cfg.NamedRetention, now, now) // not rolled up
+}
+
+func init() {
+    cfgPtr := flag.String("config", "config/retention.json", "Path to the config file")
+    flag.Parse()
+
+    f, err := os.Open(*cfgPtr)
+    if err != nil {
+        fmt.Println("Cannot open the config file:", err)
+        os.Exit(1)
+    }
+
+    err = json.NewDecoder(f).Decode(&cfg)
+    if err != nil {
+        fmt.Println("Cannot decode the config file:", err)
+        os.Exit(1)
+    }
+
+    db_dsn := cfg.MetricDB["Username"]+":"+cfg.MetricDB["Password"]+"@tcp("+cfg.MetricDB["Host"]+":"+cfg.MetricDB["Port"]+")/"+cfg.MetricDB["Database"]
+    db, err = sql.Open("mysql", db_dsn)
+    if err != nil {
+        fmt.Println("Cannot connect to the Metric database:", err)
+        os.Exit(1)
+    }
+
+    if cfg.Duration != 0 {
+        duration = cfg.Duration
+    }
+    if cfg.Lateness == 0 {
+        cfg.Lateness = 60
+    }
+    if cfg.NamedRetention == 0 {
+        cfg.NamedRetention = cfg.RawRetention
+    }
+
+    if cfg.Log != "" {
+        logf, err := os.OpenFile(cfg.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+        if err != nil {
+            fmt.Println("Cannot open the log file:", err)
+            os.Exit(1)
+        }
+        log.SetOutput(logf)
+    }
+}
+
+func main() {
+    defer db.Close()
+
+    Run()
+    ticker := time.NewTicker(time.Duration(duration) * time.Second)
+    for range ticker.C {
+        Run()
+    }
+}
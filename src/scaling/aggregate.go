@@ -0,0 +1,64 @@
+package scaling
+
+import (
+    "math"
+    "sort"
+
+    "registry"
+)
+
+type Sample struct {
+    Time int
+    Instance_uuid string
+    Value float64
+}
+
+// Aggregate reduces the samples taken in [since, until] with agg (avg, min, max,
+// last or p95). Samples are in chronological order, they are searched for the
+// bounds of the window rather than scanned. count is the number of such
+// samples, the value is meaningless when it's 0.
+func Aggregate(samples []Sample, since int, until int, agg string) (value float64, count int) {
+    var values []float64 // p95 only
+    end := sort.Search(len(samples), func(i int) bool { return samples[i].Time > until })
+    begin := sort.Search(end, func(i int) bool { return samples[i].Time >= since })
+    for i := end - 1; i >= begin; i-- {
+        s := samples[i]
+        count = count + 1
+        switch agg {
+            case registry.AggMin:
+                if count == 1 || s.Value < value {
+                    value = s.Value
+                }
+            case registry.AggMax:
+                if count == 1 || s.Value > value {
+                    value = s.Value
+                }
+            case registry.AggLast:
+                if count == 1 {
+                    value = s.Value
+                }
+            case registry.AggP95:
+                values = append(values, s.Value)
+            default: // avg
+                value = value + s.Value
+        }
+    }
+
+    if count > 0 && agg == registry.AggP95 {
+        value = Percentile(values, 95)
+    } else if count > 0 && agg != registry.AggMin && agg != registry.AggMax && agg != registry.AggLast {
+        value = value / float64(count)
+    }
+    return value, count
+}
+
+// Percentile returns the p-th percentile of vs by the nearest-rank method.
+func Percentile(vs []float64, p float64) float64 {
+    sorted := append([]float64(nil), vs...)
+    sort.Float64s(sorted)
+    rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+    if rank < 1 {
+        rank = 1
+    }
+    return sorted[rank - 1]
+}
@@ -0,0 +1,72 @@
+package scaling
+
+import (
+    "testing"
+
+    "registry"
+)
+
+func TestAggregate(t *testing.T) {
+    samples := []Sample{
+        {Time: 10, Value: 4},
+        {Time: 20, Value: 1},
+        {Time: 30, Value: 7},
+        {Time: 30, Value: 2},
+        {Time: 40, Value: 6},
+        {Time: 50, Value: 3},
+    }
+    tests := []struct {
+        name string
+        since int
+        until int
+        agg string
+        value float64
+        count int
+    }{
+        {"avg", 20, 40, registry.AggAvg, 4, 4},
+        {"bounds are inclusive", 10, 50, registry.AggAvg, 23.0 / 6, 6},
+        {"min", 20, 40, registry.AggMin, 1, 4},
+        {"max", 20, 40, registry.AggMax, 7, 4},
+        {"last", 10, 35, registry.AggLast, 2, 4},
+        {"p95", 10, 50, registry.AggP95, 7, 6},
+        {"single sample", 45, 55, registry.AggAvg, 3, 1},
+        {"before the samples", 0, 5, registry.AggAvg, 0, 0},
+        {"after the samples", 60, 70, registry.AggMax, 0, 0},
+        {"between samples", 41, 49, registry.AggAvg, 0, 0},
+    }
+    for _, tt := range tests {
+        value, count := Aggregate(samples, tt.since, tt.until, tt.agg)
+        if count != tt.count || count > 0 && value != tt.value {
+            t.Errorf("%s: Aggregate = %v, %d, want %v, %d", tt.name, value, count, tt.value, tt.count)
+        }
+    }
+
+    if _, count := Aggregate(nil, 0, 100, registry.AggAvg); count != 0 {
+        t.Errorf("Aggregate of no samples: count = %d, want 0", count)
+    }
+}
+
+func TestPercentile(t *testing.T) {
+    tests := []struct {
+        vs []float64
+        p float64
+        want float64
+    }{
+        {[]float64{5}, 95, 5},
+        {[]float64{3, 1, 2}, 50, 2},
+        {[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 95, 10},
+        {[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 90, 9},
+        {[]float64{4, 2}, 0, 2},
+    }
+    for _, tt := range tests {
+        if got := Percentile(tt.vs, tt.p); got != tt.want {
+            t.Errorf("Percentile(%v, %v) = %v, want %v", tt.vs, tt.p, got, tt.want)
+        }
+    }
+
+    vs := []float64{3, 1, 2}
+    Percentile(vs, 50)
+    if vs[0] != 3 || vs[1] != 1 || vs[2] != 2 {
+        t.Errorf("Percentile sorted its argument: %v", vs)
+    }
+}
@@ -0,0 +1,276 @@
+package scaling
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+
+    "registry"
+)
+
+// Operators of conditions
+const (
+    CondAnd = "and"
+    CondOr = "or"
+    CondNot = "not"
+)
+
+// Condition is a boolean expression over the metrics of an app, the trigger
+// of a condition policy. Comparisons hold a metric, optionally wrapped in an
+// aggregation, and a number; they are combined with and, or, not and
+// parentheses, and binds tighter than or. E.g.
+//
+//     cpu > 70 and p95(latency) > 500
+//     cpu < 30 and mem_pct < 40
+//     not (queue_ready <= 100 or max(cpu) < 50)
+type Condition struct {
+    Op string // CondAnd, CondOr, CondNot, or a comparison: >, >=, < or <=
+    Operands []Condition // of and, or and not
+    Metric string // of a comparison, name in the registry
+    Aggregation string // of a comparison, default aggregation of the metric if empty
+    Value float64 // of a comparison
+}
+
+// ParseCondition parses and checks the syntax of a condition. Metrics are
+// only checked to be valid names, see Condition.Comparisons to look them up.
+func ParseCondition(s string) (Condition, error) {
+    p := &parser{tokens: tokenize(s)}
+    c, err := p.or()
+    if err != nil {
+        return c, err
+    }
+    if p.pos < len(p.tokens) {
+        return c, errors.New("Unexpected " + p.tokens[p.pos] + " in condition")
+    }
+    return c, nil
+}
+
+// Comparisons returns the comparisons of the condition, in order.
+func (c Condition) Comparisons() []Condition {
+    if len(c.Operands) == 0 {
+        return []Condition{c}
+    }
+
+    var list []Condition
+    for _, o := range c.Operands {
+        list = append(list, o.Comparisons()...)
+    }
+    return list
+}
+
+// Key identifies the observed value of a comparison, e.g. "p95(latency)" or "cpu".
+func (c Condition) Key() string {
+    if c.Aggregation == "" {
+        return c.Metric
+    }
+    return c.Aggregation + "(" + c.Metric + ")"
+}
+
+// Eval tells whether the condition holds for the values of its comparisons, by Key.
+// ok is false when a value is missing.
+func (c Condition) Eval(values map[string]float64) (holds bool, ok bool) {
+    switch c.Op {
+        case CondAnd, CondOr:
+            holds = c.Op == CondAnd
+            for _, o := range c.Operands {
+                h, ok := o.Eval(values)
+                if ok == false {
+                    return false, false
+                }
+                if c.Op == CondAnd {
+                    holds = holds && h
+                } else {
+                    holds = holds || h
+                }
+            }
+            return holds, true
+        case CondNot:
+            h, ok := c.Operands[0].Eval(values)
+            return !h, ok
+    }
+
+    v, ok := values[c.Key()]
+    if ok == false {
+        return false, false
+    }
+    switch c.Op {
+        case ">":
+            return v > c.Value, true
+        case ">=":
+            return v >= c.Value, true
+        case "<":
+            return v < c.Value, true
+        default:
+            return v <= c.Value, true
+    }
+}
+
+func (c Condition) String() string {
+    switch c.Op {
+        case CondAnd, CondOr:
+            var parts []string
+            for _, o := range c.Operands {
+                if o.Op == CondOr && c.Op == CondAnd {
+                    parts = append(parts, "(" + o.String() + ")")
+                } else {
+                    parts = append(parts, o.String())
+                }
+            }
+            return strings.Join(parts, " " + c.Op + " ")
+        case CondNot:
+            if len(c.Operands[0].Operands) == 0 {
+                return "not " + c.Operands[0].String()
+            }
+            return "not (" + c.Operands[0].String() + ")"
+    }
+    return c.Key() + " " + c.Op + " " + strconv.FormatFloat(c.Value, 'f', -1, 64)
+}
+
+// tokenize splits s into names, numbers, operators and parentheses.
+func tokenize(s string) []string {
+    var tokens []string
+    for i := 0; i < len(s); {
+        ch := s[i]
+        switch {
+            case ch == ' ' || ch == '\t' || ch == '\n':
+                i = i + 1
+            case ch == '(' || ch == ')':
+                tokens = append(tokens, string(ch))
+                i = i + 1
+            case ch == '<' || ch == '>':
+                if i + 1 < len(s) && s[i + 1] == '=' {
+                    tokens = append(tokens, s[i:i + 2])
+                    i = i + 2
+                } else {
+                    tokens = append(tokens, string(ch))
+                    i = i + 1
+                }
+            default:
+                j := i + 1
+                for j < len(s) && strings.IndexByte(" \t\n()<>", s[j]) < 0 {
+                    j = j + 1
+                }
+                tokens = append(tokens, s[i:j])
+                i = j
+        }
+    }
+    return tokens
+}
+
+// parser is a recursive descent parser of conditions:
+//
+//     or         = and { "or" and }
+//     and        = not { "and" not }
+//     not        = "not" not | "(" or ")" | comparison
+//     comparison = operand ( ">" | ">=" | "<" | "<=" ) number
+//     operand    = metric | aggregation "(" metric ")"
+type parser struct {
+    tokens []string
+    pos int
+}
+
+func (p *parser) peek() string {
+    if p.pos < len(p.tokens) {
+        return p.tokens[p.pos]
+    }
+    return ""
+}
+
+func (p *parser) next() string {
+    t := p.peek()
+    p.pos = p.pos + 1
+    return t
+}
+
+func (p *parser) expect(t string) error {
+    if p.peek() != t {
+        return errors.New("Expected " + t + " in condition")
+    }
+    p.pos = p.pos + 1
+    return nil
+}
+
+func (p *parser) or() (Condition, error) {
+    return p.list(CondOr, p.and)
+}
+
+func (p *parser) and() (Condition, error) {
+    return p.list(CondAnd, p.not)
+}
+
+// list parses operands separated by the keyword op, in any case.
+func (p *parser) list(op string, operand func() (Condition, error)) (Condition, error) {
+    c, err := operand()
+    if err != nil {
+        return c, err
+    }
+
+    operands := []Condition{c}
+    for strings.ToLower(p.peek()) == op {
+        p.next()
+        c, err := operand()
+        if err != nil {
+            return c, err
+        }
+        operands = append(operands, c)
+    }
+    if len(operands) == 1 {
+        return c, nil
+    }
+    return Condition{Op: op, Operands: operands}, nil
+}
+
+func (p *parser) not() (Condition, error) {
+    switch strings.ToLower(p.peek()) {
+        case CondNot:
+            p.next()
+            c, err := p.not()
+            if err != nil {
+                return c, err
+            }
+            return Condition{Op: CondNot, Operands: []Condition{c}}, nil
+        case "(":
+            p.next()
+            c, err := p.or()
+            if err != nil {
+                return c, err
+            }
+            return c, p.expect(")")
+        case "":
+            return Condition{}, errors.New("Unexpected end of condition")
+    }
+    return p.comparison()
+}
+
+func (p *parser) comparison() (Condition, error) {
+    var c Condition
+    name := p.next()
+    if p.peek() == "(" {
+        p.next()
+        if registry.IsValidAggregation(name) == false {
+            return c, errors.New("Unknown aggregation " + name + " in condition")
+        }
+        c.Aggregation, name = name, p.next()
+        if err := p.expect(")"); err != nil {
+            return c, err
+        }
+    }
+    if registry.IsValidName(name) == false {
+        return c, errors.New("Invalid metric name " + name + " in condition")
+    }
+    c.Metric = name
+
+    switch op := p.next(); op {
+        case ">", ">=", "<", "<=":
+            c.Op = op
+        default:
+            return c, errors.New("Expected a comparison after " + c.Key() + " in condition")
+    }
+
+    v, err := strconv.ParseFloat(p.next(), 64)
+    if err != nil {
+        return c, errors.New("Expected a number after " + c.Key() + " " + c.Op + " in condition")
+    }
+    c.Value = v
+    return c, nil
+}
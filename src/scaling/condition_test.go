@@ -0,0 +1,117 @@
+package scaling
+
+import (
+    "testing"
+)
+
+func TestParseCondition(t *testing.T) {
+    tests := []struct {
+        s string
+        want string // String of the parsed condition
+    }{
+        {"cpu > 70", "cpu > 70"},
+        {"cpu>=70.5", "cpu >= 70.5"},
+        {"p95(latency) > 500", "p95(latency) > 500"},
+        {"p95 ( latency ) <= 500", "p95(latency) <= 500"},
+        {"cpu > 70 and p95(latency) > 500", "cpu > 70 and p95(latency) > 500"},
+        {"cpu > 70 AND mem < 40 Or disk > 90", "cpu > 70 and mem < 40 or disk > 90"},
+        {"cpu > 70 or mem < 40 and disk > 90", "cpu > 70 or mem < 40 and disk > 90"},
+        {"(cpu > 70 or mem < 40) and disk > 90", "(cpu > 70 or mem < 40) and disk > 90"},
+        {"not cpu > 70", "not cpu > 70"},
+        {"not (queue_ready <= 100 or max(cpu) < 50)", "not (queue_ready <= 100 or max(cpu) < 50)"},
+        {"((cpu > 70))", "cpu > 70"},
+    }
+    for _, tt := range tests {
+        c, err := ParseCondition(tt.s)
+        if err != nil {
+            t.Errorf("ParseCondition(%q): %v", tt.s, err)
+            continue
+        }
+        if got := c.String(); got != tt.want {
+            t.Errorf("ParseCondition(%q) = %q, want %q", tt.s, got, tt.want)
+        }
+    }
+}
+
+func TestParseConditionPrecedence(t *testing.T) {
+    // and binds tighter than or
+    c, err := ParseCondition("cpu > 70 or mem < 40 and disk > 90")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if c.Op != CondOr || len(c.Operands) != 2 || c.Operands[1].Op != CondAnd {
+        t.Errorf("cpu > 70 or mem < 40 and disk > 90 parsed as %+v", c)
+    }
+
+    // not binds tighter than and
+    c, err = ParseCondition("not cpu > 70 and mem < 40")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if c.Op != CondAnd || c.Operands[0].Op != CondNot {
+        t.Errorf("not cpu > 70 and mem < 40 parsed as %+v", c)
+    }
+}
+
+func TestParseConditionMalformed(t *testing.T) {
+    for _, s := range []string{
+        "",
+        "cpu",
+        "cpu >",
+        "cpu > high",
+        "cpu = 70",
+        "> 70",
+        "cpu > 70 and",
+        "cpu > 70 or or mem < 40",
+        "(cpu > 70",
+        "cpu > 70)",
+        "not",
+        "median(cpu) > 70",
+        "p95(latency > 500",
+        "p95() > 500",
+        "CPU > 70",
+        "cpu > 70 mem < 40",
+    } {
+        if c, err := ParseCondition(s); err == nil {
+            t.Errorf("ParseCondition(%q) = %q, want an error", s, c.String())
+        }
+    }
+}
+
+func TestConditionComparisons(t *testing.T) {
+    c, err := ParseCondition("cpu > 70 and (p95(latency) > 500 or not mem < 40)")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var keys []string
+    for _, cmp := range c.Comparisons() {
+        keys = append(keys, cmp.Key())
+    }
+    if len(keys) != 3 || keys[0] != "cpu" || keys[1] != "p95(latency)" || keys[2] != "mem" {
+        t.Errorf("Comparisons = %v, want [cpu p95(latency) mem]", keys)
+    }
+}
+
+func TestConditionEval(t *testing.T) {
+    c, err := ParseCondition("cpu > 70 and (p95(latency) > 500 or not mem < 40)")
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        values map[string]float64
+        holds bool
+        ok bool
+    }{
+        {map[string]float64{"cpu": 80, "p95(latency)": 600, "mem": 10}, true, true},
+        {map[string]float64{"cpu": 80, "p95(latency)": 100, "mem": 50}, true, true},
+        {map[string]float64{"cpu": 80, "p95(latency)": 100, "mem": 10}, false, true},
+        {map[string]float64{"cpu": 70, "p95(latency)": 600, "mem": 50}, false, true},
+        {map[string]float64{"cpu": 80, "mem": 50}, false, false},
+    }
+    for _, tt := range tests {
+        holds, ok := c.Eval(tt.values)
+        if holds != tt.holds || ok != tt.ok {
+            t.Errorf("Eval(%v) = %v, %v, want %v, %v", tt.values, holds, ok, tt.holds, tt.ok)
+        }
+    }
+}
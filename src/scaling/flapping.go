@@ -0,0 +1,29 @@
+package scaling
+
+// An app flaps when its scaling reverses direction, e.g. out, in, out, at
+// least DefaultFlapReversals times within DefaultFlapWindow seconds. It is then
+// held at its higher number of instances: scaling in cools down for
+// DefaultFlapHold seconds. The engine may be configured otherwise.
+const DefaultFlapWindow = 1800 // seconds
+const DefaultFlapReversals = 3
+const DefaultFlapHold = 1800 // seconds
+
+// Reversals counts the changes of direction of the scalings of the history
+// since the unix time since.
+func Reversals(history []Scaling, since int) int {
+    reversals := 0
+    last := ""
+    for _, s := range history {
+        if s.Time <= since {
+            continue
+        }
+        if s.Scale != ScaleOut && s.Scale != ScaleIn {
+            continue
+        }
+        if last != "" && s.Scale != last {
+            reversals = reversals + 1
+        }
+        last = s.Scale
+    }
+    return reversals
+}
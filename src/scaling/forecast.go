@@ -0,0 +1,135 @@
+package scaling
+
+import (
+    "errors"
+    "math"
+)
+
+// DefaultForecastLead is how far ahead of an evaluation predictive policies
+// look at the forecast when their Forecast_lead is 0, in seconds.
+const DefaultForecastLead = 900
+
+var ErrShortSeries = errors.New("Series shorter than two seasons")
+
+// HoltWinters is the additive Holt-Winters model of a seasonal series: a
+// level, a trend and a seasonal component of Season points, smoothed by
+// Alpha, Beta and Gamma in [0, 1].
+type HoltWinters struct {
+    Alpha float64 // smoothing of the level
+    Beta float64 // smoothing of the trend
+    Gamma float64 // smoothing of the seasonal component
+    Season int // points per season, e.g. 672 for a weekly season of 15 minutes points
+    Rmse float64 // root mean squared error of the one step predictions over the series, set by Forecast
+}
+
+// Candidate smoothing parameters tried by FitHoltWinters
+var alphas = []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+var betas = []float64{0, 0.01, 0.05, 0.1}
+var gammas = []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+
+// Forecast smooths the series, at least two seasons of equally spaced points
+// without gaps, and predicts the horizon points following it. The returned
+// model holds the error of its one step predictions over the series.
+func (hw HoltWinters) Forecast(series []float64, horizon int) ([]float64, HoltWinters, error) {
+    m := hw.Season
+    if m < 1 || len(series) < 2 * m {
+        return nil, hw, ErrShortSeries
+    }
+
+    // Initial level and seasonal component from the first season, trend from the first two
+    level := mean(series[:m])
+    trend := (mean(series[m:2 * m]) - level) / float64(m)
+    seasonal := make([]float64, m)
+    for i := 0; i < m; i++ {
+        seasonal[i] = series[i] - level
+    }
+
+    sse := 0.0
+    for t := m; t < len(series); t++ {
+        predicted := level + trend + seasonal[t % m]
+        sse = sse + (series[t] - predicted) * (series[t] - predicted)
+
+        last := level
+        level = hw.Alpha * (series[t] - seasonal[t % m]) + (1 - hw.Alpha) * (level + trend)
+        trend = hw.Beta * (level - last) + (1 - hw.Beta) * trend
+        seasonal[t % m] = hw.Gamma * (series[t] - level) + (1 - hw.Gamma) * seasonal[t % m]
+    }
+    hw.Rmse = math.Sqrt(sse / float64(len(series) - m))
+
+    forecast := make([]float64, horizon)
+    for h := 1; h <= horizon; h++ {
+        forecast[h - 1] = level + float64(h) * trend + seasonal[(len(series) + h - 1) % m]
+    }
+    return forecast, hw, nil
+}
+
+// FitHoltWinters picks the smoothing parameters which predict the series best,
+// one step ahead, and forecasts the horizon points following it with them.
+func FitHoltWinters(series []float64, season int, horizon int) ([]float64, HoltWinters, error) {
+    var best HoltWinters
+    var forecast []float64
+    found := false
+    for _, alpha := range alphas {
+        for _, beta := range betas {
+            for _, gamma := range gammas {
+                f, hw, err := HoltWinters{Alpha: alpha, Beta: beta, Gamma: gamma, Season: season}.Forecast(series, horizon)
+                if err != nil {
+                    return nil, hw, err
+                }
+                if found == false || hw.Rmse < best.Rmse {
+                    forecast, best, found = f, hw, true
+                }
+            }
+        }
+    }
+    return forecast, best, nil
+}
+
+// FillGaps replaces the missing points of a series, NaN, in place: by the
+// point a season earlier when there is one, else by the previous point, and
+// leading ones by the first known point. It returns the number of points
+// which were missing, all of them when none is known.
+func FillGaps(series []float64, season int) int {
+    first := -1
+    for i, v := range series {
+        if math.IsNaN(v) == false {
+            first = i
+            break
+        }
+    }
+    if first < 0 {
+        return len(series)
+    }
+
+    missing := 0
+    for i, v := range series {
+        if math.IsNaN(v) == false {
+            continue
+        }
+        missing = missing + 1
+        if i < first {
+            series[i] = series[first]
+        } else if season > 0 && i >= season {
+            series[i] = series[i - season]
+        } else {
+            series[i] = series[i - 1]
+        }
+    }
+    return missing
+}
+
+// ForecastLead returns how far ahead of an evaluation a predictive policy looks at the forecast.
+func ForecastLead(policy Policy) int {
+    if policy.Forecast_lead > 0 {
+        return policy.Forecast_lead
+    }
+    return DefaultForecastLead
+}
+
+func mean(vs []float64) float64 {
+    sum := 0.0
+    for _, v := range vs {
+        sum = sum + v
+    }
+    return sum / float64(len(vs))
+}
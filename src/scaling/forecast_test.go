@@ -0,0 +1,121 @@
+package scaling
+
+import (
+    "math"
+    "testing"
+)
+
+// seasonal returns n points of a daily-like pattern of season points, on a
+// level growing by trend per point.
+func seasonal(n int, season int, trend float64) []float64 {
+    series := make([]float64, n)
+    for i := range series {
+        series[i] = 100 + trend * float64(i) + 40 * math.Sin(2 * math.Pi * float64(i % season) / float64(season))
+    }
+    return series
+}
+
+func TestFitHoltWinters(t *testing.T) {
+    tests := []struct {
+        name string
+        trend float64
+        rmse float64 // at most
+        tolerance float64 // fraction of the actual points the forecast may be off by
+    }{
+        {"seasonal", 0, 0.01, 0.001},
+        {"seasonal with trend", 0.5, 5, 0.1},
+    }
+    for _, tt := range tests {
+        season, horizon := 24, 12
+        all := seasonal(4 * season + horizon, season, tt.trend)
+        series, future := all[:4 * season], all[4 * season:]
+
+        forecast, model, err := FitHoltWinters(series, season, horizon)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(forecast) != horizon {
+            t.Fatalf("%s: forecast of %d points, want %d", tt.name, len(forecast), horizon)
+        }
+        if model.Season != season || model.Rmse > tt.rmse {
+            t.Errorf("%s: model = %+v, want a season of %d and an error under %v", tt.name, model, season, tt.rmse)
+        }
+        for h, f := range forecast {
+            if math.Abs(f - future[h]) > tt.tolerance * future[h] {
+                t.Errorf("%s: forecast %d steps ahead = %v, want about %v", tt.name, h + 1, f, future[h])
+            }
+        }
+    }
+}
+
+func TestHoltWintersShortSeries(t *testing.T) {
+    if _, _, err := FitHoltWinters(seasonal(47, 24, 0), 24, 1); err != ErrShortSeries {
+        t.Errorf("err = %v, want %v", err, ErrShortSeries)
+    }
+    if _, _, err := (HoltWinters{Alpha: 0.5}).Forecast(seasonal(48, 24, 0), 1); err != ErrShortSeries {
+        t.Errorf("err of a model without season = %v, want %v", err, ErrShortSeries)
+    }
+}
+
+func TestFillGaps(t *testing.T) {
+    nan := math.NaN()
+    tests := []struct {
+        name string
+        series []float64
+        season int
+        want []float64
+        missing int
+    }{
+        {"none missing", []float64{1, 2, 3}, 2, []float64{1, 2, 3}, 0},
+        {"a season earlier", []float64{1, 2, 3, nan, 5, nan}, 2, []float64{1, 2, 3, 2, 5, 2}, 2},
+        {"previous point in the first season", []float64{1, nan, 3, 4}, 2, []float64{1, 1, 3, 4}, 1},
+        {"leading", []float64{nan, nan, 3, 4}, 2, []float64{3, 3, 3, 4}, 2},
+        {"without season", []float64{1, nan, nan, 4}, 0, []float64{1, 1, 1, 4}, 2},
+        {"a season earlier, itself missing", []float64{1, nan, 3, nan}, 2, []float64{1, 1, 3, 1}, 2},
+    }
+    for _, tt := range tests {
+        missing := FillGaps(tt.series, tt.season)
+        if missing != tt.missing {
+            t.Errorf("%s: missing = %d, want %d", tt.name, missing, tt.missing)
+        }
+        for i := range tt.want {
+            if tt.series[i] != tt.want[i] {
+                t.Errorf("%s: series = %v, want %v", tt.name, tt.series, tt.want)
+                break
+            }
+        }
+    }
+
+    series := []float64{nan, nan}
+    if missing := FillGaps(series, 1); missing != 2 || math.IsNaN(series[0]) == false {
+        t.Errorf("FillGaps of no known point = %d, %v, want 2 left as is", missing, series)
+    }
+}
+
+func TestFillGapsThenForecast(t *testing.T) {
+    season := 24
+    series := seasonal(4 * season, season, 0)
+    for _, i := range []int{30, 31, 55, 70} {
+        series[i] = math.NaN()
+    }
+    if missing := FillGaps(series, season); missing != 4 {
+        t.Errorf("missing = %d, want 4", missing)
+    }
+    forecast, _, err := FitHoltWinters(series, season, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := seasonal(4 * season + 1, season, 0)[4 * season]
+    if math.Abs(forecast[0] - want) > 1 {
+        t.Errorf("forecast = %v, want about %v", forecast[0], want)
+    }
+}
+
+func TestForecastLead(t *testing.T) {
+    if lead := ForecastLead(Policy{}); lead != DefaultForecastLead {
+        t.Errorf("ForecastLead = %d, want %d", lead, DefaultForecastLead)
+    }
+    if lead := ForecastLead(Policy{Forecast_lead: 300}); lead != 300 {
+        t.Errorf("ForecastLead = %d, want 300", lead)
+    }
+}
@@ -0,0 +1,176 @@
+package scaling
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Protection restricts scaling in of an app, scaling out remains allowed.
+type Protection struct {
+    Max_in_per_hour int // instances which may be removed within an hour, unlimited if 0
+    Min_instance_age int // seconds since the last scale out before scaling in, as the newest instances are removed first
+    Windows []Window // scaling in is blocked within them
+    Location *time.Location // of the windows, UTC if nil
+}
+
+// Scaling is a past scaling of an app, from its history.
+type Scaling struct {
+    Time int
+    Scale string
+    Num_before int
+    Num_after int
+}
+
+// Window is a weekly period, e.g. "mon-fri 09:00-18:00". It spans midnight
+// when it ends before it starts, e.g. "fri 22:00-06:00" ends on saturday. It
+// never starts and ends at the same time, "00:00-24:00" is the whole day.
+type Window struct {
+    Days [7]bool // by time.Weekday
+    Start int // minutes since midnight
+    End int
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Restrict returns the directions the app can't be scaled in at now with the
+// reason, and the most instances it can remove, given its scaling of the last
+// hour at least. See Evaluator.Holds and Evaluator.Limits.
+func (p Protection) Restrict(now int, history []Scaling) (map[string]string, map[string]int) {
+    holds := make(map[string]string)
+    limits := make(map[string]int)
+
+    t := time.Unix(int64(now), 0).UTC()
+    if p.Location != nil {
+        t = t.In(p.Location)
+    }
+    for _, w := range p.Windows {
+        if w.Contains(t) {
+            holds[ScaleIn] = "protection window " + w.String()
+            return holds, limits
+        }
+    }
+
+    removed := 0
+    last_out := 0
+    for _, s := range history {
+        if s.Scale == ScaleIn && s.Time > now - 3600 && s.Num_before > s.Num_after {
+            removed = removed + s.Num_before - s.Num_after
+        }
+        if s.Scale == ScaleOut && s.Time > last_out {
+            last_out = s.Time
+        }
+    }
+
+    if p.Min_instance_age > 0 && now - last_out < p.Min_instance_age {
+        holds[ScaleIn] = fmt.Sprintf("instances added %d seconds ago, younger than %d seconds", now - last_out, p.Min_instance_age)
+        return holds, limits
+    }
+    if p.Max_in_per_hour > 0 {
+        if removed >= p.Max_in_per_hour {
+            holds[ScaleIn] = fmt.Sprintf("%d instances removed within the last hour, at most %d", removed, p.Max_in_per_hour)
+            return holds, limits
+        }
+        limits[ScaleIn] = p.Max_in_per_hour - removed
+    }
+    return holds, limits
+}
+
+// ParseWindows parses comma separated windows, each made of optional days,
+// a day or a range of days, and a range of times, e.g. "mon-fri 09:00-18:00, sat 10:00-14:00".
+// A range of days may wrap around the week, e.g. "fri-mon". A range of times
+// which starts and ends at the same time is rejected, being either empty or a
+// whole day.
+func ParseWindows(s string) ([]Window, error) {
+    var windows []Window
+    for _, part := range strings.Split(s, ",") {
+        fields := strings.Fields(strings.ToLower(part))
+        if len(fields) == 0 {
+            continue
+        }
+        if len(fields) > 2 {
+            return nil, errors.New("Invalid window: " + part)
+        }
+
+        var w Window
+        if len(fields) == 1 {
+            for d := range w.Days {
+                w.Days[d] = true
+            }
+        } else {
+            days := strings.Split(fields[0], "-")
+            first, last := dayOf(days[0]), dayOf(days[len(days) - 1])
+            if len(days) > 2 || first < 0 || last < 0 {
+                return nil, errors.New("Invalid days of window: " + fields[0])
+            }
+            for d := first; ; d = (d + 1) % 7 {
+                w.Days[d] = true
+                if d == last {
+                    break
+                }
+            }
+        }
+
+        times := strings.Split(fields[len(fields) - 1], "-")
+        if len(times) != 2 {
+            return nil, errors.New("Invalid times of window: " + fields[len(fields) - 1])
+        }
+        var err error
+        if w.Start, err = minuteOf(times[0]); err != nil {
+            return nil, err
+        }
+        if w.End, err = minuteOf(times[1]); err != nil {
+            return nil, err
+        }
+        if w.Start == w.End {
+            return nil, errors.New("Empty window, 00:00-24:00 is the whole day: " + part)
+        }
+        windows = append(windows, w)
+    }
+    return windows, nil
+}
+
+// Contains tells whether t, in the location of the window, is within it.
+func (w Window) Contains(t time.Time) bool {
+    m := t.Hour() * 60 + t.Minute()
+    d := int(t.Weekday())
+    if w.Start < w.End {
+        return w.Days[d] && m >= w.Start && m < w.End
+    }
+    return w.Days[d] && m >= w.Start || w.Days[(d + 6) % 7] && m < w.End
+}
+
+func (w Window) String() string {
+    var days []string
+    for d, in := range w.Days {
+        if in {
+            days = append(days, weekdays[d])
+        }
+    }
+    return fmt.Sprintf("%s %02d:%02d-%02d:%02d", strings.Join(days, ","), w.Start / 60, w.Start % 60, w.End / 60, w.End % 60)
+}
+
+func dayOf(s string) int {
+    for d, name := range weekdays {
+        if s == name {
+            return d
+        }
+    }
+    return -1
+}
+
+// minuteOf parses "HH:MM" into minutes since midnight, "24:00" being the end of the day.
+func minuteOf(s string) (int, error) {
+    parts := strings.Split(s, ":")
+    if len(parts) != 2 {
+        return 0, errors.New("Invalid time of window: " + s)
+    }
+    h, err1 := strconv.Atoi(parts[0])
+    m, err2 := strconv.Atoi(parts[1])
+    if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h * 60 + m > 24 * 60 {
+        return 0, errors.New("Invalid time of window: " + s)
+    }
+    return h * 60 + m, nil
+}
@@ -0,0 +1,139 @@
+package scaling
+
+import (
+    "testing"
+    "time"
+)
+
+// at returns the time of day hh:mm of the day of the first week of 2024,
+// which starts on monday the 1st.
+func at(day string, hh int, mm int) time.Time {
+    d := (dayOf(day) + 6) % 7 // days since monday
+    return time.Date(2024, time.January, 1 + d, hh, mm, 0, 0, time.UTC)
+}
+
+func TestParseWindows(t *testing.T) {
+    tests := []struct {
+        s string
+        want []string // String of each window
+    }{
+        {"", nil},
+        {"09:00-18:00", []string{"sun,mon,tue,wed,thu,fri,sat 09:00-18:00"}},
+        {"mon-fri 09:00-18:00, sat 10:00-14:00", []string{"mon,tue,wed,thu,fri 09:00-18:00", "sat 10:00-14:00"}},
+        {"fri-mon 00:00-24:00", []string{"sun,mon,fri,sat 00:00-24:00"}},
+        {"sat-sat 10:00-11:00", []string{"sat 10:00-11:00"}},
+        {"FRI 22:00-06:00", []string{"fri 22:00-06:00"}},
+        {"mon 09:00-10:00,", []string{"mon 09:00-10:00"}},
+    }
+    for _, tt := range tests {
+        windows, err := ParseWindows(tt.s)
+        if err != nil {
+            t.Errorf("ParseWindows(%q): %v", tt.s, err)
+            continue
+        }
+        if len(windows) != len(tt.want) {
+            t.Errorf("ParseWindows(%q) = %v, want %v", tt.s, windows, tt.want)
+            continue
+        }
+        for i, w := range windows {
+            if w.String() != tt.want[i] {
+                t.Errorf("ParseWindows(%q) = %v, want %v", tt.s, windows, tt.want)
+                break
+            }
+        }
+    }
+}
+
+func TestParseWindowsInvalid(t *testing.T) {
+    for _, s := range []string{
+        "mon",
+        "mon 09:00",
+        "mon 09:00-18:00 extra",
+        "monday 09:00-18:00",
+        "mon-tue-wed 09:00-18:00",
+        "mon 9-18",
+        "mon 09:60-18:00",
+        "mon 09:00-24:01",
+        "mon 25:00-26:00",
+        "mon 09:00-09:00",
+        "00:00-00:00",
+    } {
+        if windows, err := ParseWindows(s); err == nil {
+            t.Errorf("ParseWindows(%q) = %v, want an error", s, windows)
+        }
+    }
+}
+
+func TestWindowContains(t *testing.T) {
+    tests := []struct {
+        window string
+        t time.Time
+        want bool
+    }{
+        {"mon-fri 09:00-18:00", at("wed", 9, 0), true},
+        {"mon-fri 09:00-18:00", at("wed", 17, 59), true},
+        {"mon-fri 09:00-18:00", at("wed", 18, 0), false},
+        {"mon-fri 09:00-18:00", at("wed", 8, 59), false},
+        {"mon-fri 09:00-18:00", at("sat", 12, 0), false},
+        // Days wrapping around the week
+        {"fri-mon 09:00-18:00", at("sun", 12, 0), true},
+        {"fri-mon 09:00-18:00", at("mon", 12, 0), true},
+        {"fri-mon 09:00-18:00", at("tue", 12, 0), false},
+        {"fri-mon 09:00-18:00", at("thu", 12, 0), false},
+        // Times past midnight, ending on the next day
+        {"fri 22:00-06:00", at("fri", 23, 0), true},
+        {"fri 22:00-06:00", at("sat", 5, 59), true},
+        {"fri 22:00-06:00", at("sat", 6, 0), false},
+        {"fri 22:00-06:00", at("fri", 5, 0), false},
+        {"fri 22:00-06:00", at("sat", 23, 0), false},
+        {"sat 22:00-06:00", at("sun", 1, 0), true},
+        {"sun-sat 22:00-06:00", at("mon", 3, 0), true},
+        // Whole days
+        {"sat 00:00-24:00", at("sat", 0, 0), true},
+        {"sat 00:00-24:00", at("sat", 23, 59), true},
+        {"sat 00:00-24:00", at("sun", 0, 0), false},
+    }
+    for _, tt := range tests {
+        windows, err := ParseWindows(tt.window)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := windows[0].Contains(tt.t); got != tt.want {
+            t.Errorf("%q contains %s: %v, want %v", tt.window, tt.t.Format("Mon 15:04"), got, tt.want)
+        }
+    }
+}
+
+func TestRestrict(t *testing.T) {
+    now := int(at("wed", 12, 0).Unix())
+    windows, _ := ParseWindows("wed 11:00-13:00")
+    paris, _ := time.LoadLocation("Europe/Paris")
+    tests := []struct {
+        name string
+        p Protection
+        history []Scaling
+        held bool
+        limit int // 0 if unlimited
+    }{
+        {"no protection", Protection{}, nil, false, 0},
+        {"within a window", Protection{Windows: windows}, nil, true, 0},
+        {"window in another location", Protection{Windows: windows, Location: paris}, nil, false, 0},
+        {"young instances", Protection{Min_instance_age: 600}, []Scaling{{Time: now - 300, Scale: ScaleOut, Num_before: 2, Num_after: 3}}, true, 0},
+        {"old instances", Protection{Min_instance_age: 600}, []Scaling{{Time: now - 900, Scale: ScaleOut, Num_before: 2, Num_after: 3}}, false, 0},
+        {"instances left to remove", Protection{Max_in_per_hour: 3}, []Scaling{{Time: now - 600, Scale: ScaleIn, Num_before: 5, Num_after: 4}}, false, 2},
+        {"all instances removed", Protection{Max_in_per_hour: 3}, []Scaling{{Time: now - 600, Scale: ScaleIn, Num_before: 6, Num_after: 3}}, true, 0},
+        {"removed over an hour ago", Protection{Max_in_per_hour: 3}, []Scaling{{Time: now - 3600, Scale: ScaleIn, Num_before: 6, Num_after: 3}}, false, 3},
+    }
+    for _, tt := range tests {
+        holds, limits := tt.p.Restrict(now, tt.history)
+        if _, held := holds[ScaleIn]; held != tt.held {
+            t.Errorf("%s: held = %v, want %v", tt.name, holds, tt.held)
+        }
+        if _, held := holds[ScaleOut]; held {
+            t.Errorf("%s: scale out held", tt.name)
+        }
+        if limits[ScaleIn] != tt.limit {
+            t.Errorf("%s: limit = %d, want %d", tt.name, limits[ScaleIn], tt.limit)
+        }
+    }
+}
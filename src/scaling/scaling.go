@@ -0,0 +1,705 @@
+// Package scaling holds the decision logic of the engine: which policy
+// breaches, in which direction and to how many instances. It is shared by the
+// engine, which acts on live metrics, and the api, which replays recorded
+// metrics to backtest policies.
+package scaling
+
+import (
+    "errors"
+    "fmt"
+    "math"
+
+    "registry"
+)
+
+// Directions of a decision
+const (
+    ScaleOut = "out"
+    ScaleIn = "in"
+)
+
+// Types of policies
+const (
+    PolicyThreshold = "threshold" // add Instances_out above Upper_threshold, remove Instances_in below Lower_threshold
+    PolicyTarget = "target" // keep the metric close to Target_value, in proportion to the number of instances
+    PolicyStep = "step" // add or remove the Instances of the band of Steps the metric falls in
+    PolicyCondition = "condition" // add Instances_out when Out_condition holds, remove Instances_in when In_condition holds
+)
+
+// Types of adjustments of threshold and step policies
+const (
+    AdjustCount = "count" // Instances_out, Instances_in and the Instances of steps are numbers of instances
+    AdjustPercent = "percent" // they are percentages of the current number of instances
+)
+
+var ErrMaximum = errors.New("Already at maximum number of instances")
+var ErrMinimum = errors.New("Already at minimum number of instances")
+
+type Policy struct {
+    Policy_uuid string
+    Policy_type string // PolicyThreshold if empty
+    Metric_type int // legacy, used when Metric_name is empty
+    Metric_name string // name in the metric registry
+    Per_instance bool // thresholds are per instance, the metric is divided by the number of instances
+    Upper_threshold float64
+    Lower_threshold float64
+    Instances_out int
+    Instances_in int
+    Target_value float64 // target policies only
+    Tolerance float64 // target policies only, fraction of Target_value within which the app isn't scaled
+    Steps []Step // step policies only
+    Out_condition string // condition policies only, see Condition, never holds if empty
+    In_condition string // condition policies only
+    Adjustment_type string // AdjustCount if empty
+    Min_adjustment int // percent adjustments only, least number of instances to add or remove, 1 if 0
+    Breach_evaluations int // consecutive evaluations deciding the same scaling before acting, 1 if 0
+    Breach_duration int // seconds the policy must have decided the same scaling before acting
+    Cooldown_period int // seconds, of both directions unless Cooldown_out or Cooldown_in is set
+    Cooldown_out int // seconds scaling out cools down after this policy scaled out
+    Cooldown_in int // seconds scaling in cools down after this policy scaled in
+    Measurement_period int
+    Predictive bool // threshold, target and step policies only, the forecast of the metric is compared when higher than the observed value
+    Forecast_lead int // seconds ahead of the evaluation the forecast is taken at, DefaultForecastLead if 0
+}
+
+// Breach is the state of a policy across evaluations: how long it has been
+// deciding the same scaling.
+type Breach struct {
+    Scale string // ScaleOut or ScaleIn, empty when the policy decided nothing at its last evaluation
+    Evaluations int // consecutive evaluations which decided Scale
+    Since int // unix time of the first of them
+}
+
+// Next returns the breach after an evaluation at now which decided scale, empty if nothing.
+func (b Breach) Next(scale string, now int) Breach {
+    if scale == "" {
+        return Breach{}
+    }
+    if scale != b.Scale {
+        return Breach{Scale: scale, Evaluations: 1, Since: now}
+    }
+    b.Evaluations = b.Evaluations + 1
+    return b
+}
+
+// Lasted tells whether the breach lasted long enough for the policy to act at now.
+func (b Breach) Lasted(policy Policy, now int) bool {
+    return b.Evaluations >= policy.Breach_evaluations && now - b.Since >= policy.Breach_duration
+}
+
+// Step is a band of a step policy. A ScaleOut step applies above its
+// Threshold, up to the Threshold of the next ScaleOut step; a ScaleIn step
+// applies below its Threshold, down to the Threshold of the next ScaleIn step.
+// E.g. out 70 +1, out 85 +3, out 95 +5 adds 1 instance in (70, 85], 3 in
+// (85, 95] and 5 above 95.
+type Step struct {
+    Scale string // ScaleOut or ScaleIn
+    Threshold float64
+    Instances int // to add or remove
+}
+
+// Decision is a policy breaching one of its thresholds.
+type Decision struct {
+    Policy int // index of the policy
+    Scale string // ScaleOut or ScaleIn
+    Metric string // metric name, suffixed by " per instance" for per instance policies, or condition of a condition policy
+    Aggregation string
+    Value float64 // value of the metric compared to the threshold
+    Threshold float64 // or target value of target policies
+    Instances int // instances to add or remove, at least when Percent isn't 0
+    Percent int // percent of the current instances to add or remove, 0 for absolute adjustments
+    Desired int // number of instances wanted by a target policy, 0 for other policies
+    Values map[string]float64 // observed values of the comparisons of a condition policy, by Condition.Key
+    Resolution string // why the decision won over those of the other policies
+    Forecast float64 // forecast of the metric of a predictive policy when it replaced the observed value, 0 otherwise
+    Limit int // most instances the decision may add or remove, unlimited if 0
+    Cooldown int // seconds scaling in the direction of Scale cools down after the decision
+}
+
+// Observer returns the aggregated value of the metric of a policy over its
+// measurement period, with the aggregation and the number of samples.
+type Observer func(p Policy, m registry.Metric) (value float64, agg string, samples int, err error)
+
+// Forecaster returns the forecast of the metric of a predictive policy at the
+// unix time at, ok is false when there is none.
+type Forecaster func(p Policy, m registry.Metric, at int) (value float64, ok bool, err error)
+
+// Evaluator checks the policies of an app and resolves their decisions.
+type Evaluator struct {
+    Policies []Policy
+    Observe Observer
+    Forecast Forecaster // nil to ignore the forecasts, predictive policies then only observe
+    Instances func() (int, error) // current number of instances, only asked for per instance and target policies
+    Log func(v ...interface{}) // nil to be quiet
+    Breaches []Breach // of each policy, left by the previous evaluation and updated in place by Run; nil to act on the first breach
+    Now int // unix time of the evaluation, for Breaches and Cooldowns
+    Cooldowns map[string]int // unix time until which scaling cools down, by direction
+    Holds map[string]string // directions the app can't be scaled in, with the reason, see Protection
+    Limits map[string]int // most instances a decision may add or remove, by direction, unlimited if absent
+}
+
+// PolicyMetric returns the registered metric a policy refers to,
+// by Metric_name or by the legacy Metric_type.
+func PolicyMetric(policy Policy) (registry.Metric, bool) {
+    if policy.Metric_name != "" {
+        return registry.Lookup(policy.Metric_name)
+    }
+    return registry.ByType(policy.Metric_type)
+}
+
+// Run evaluates every policy, resolves their decisions into one and calls act
+// with it: any scale out beats scale in, the largest scale out wins, and the
+// app is only scaled in when every policy decides so, by the smallest scale
+// in. Ties go to the first policy. Policies without data, with values out of
+// range or failing to be observed decide nothing, hence prevent scaling in, as
+// do policies whose breach hasn't lasted long enough yet. The app isn't
+// scaled in a direction which cools down or is held, see Cooldowns and Holds,
+// and isn't evaluated at all while both are. Instances is asked for once at
+// most, and only when the winning direction can scale.
+// ok is true when act returns true, i.e. the decision was carried out.
+func (e Evaluator) Run(act func(Decision) bool) (Decision, bool) {
+    out, blocked_out := e.blocked(ScaleOut)
+    in, blocked_in := e.blocked(ScaleIn)
+    if blocked_out && blocked_in {
+        e.log("No evaluation, scaling", ScaleOut, out, "and", ScaleIn, in)
+        return Decision{}, false
+    }
+    e.Instances = once(e.Instances)
+
+    var decisions []Decision
+    for i, policy := range e.Policies {
+        var d Decision
+        var ok bool
+        if policy.Policy_type == PolicyCondition {
+            d, ok = e.condition(i, policy)
+        } else {
+            d, ok = e.evaluate(i, policy)
+        }
+
+        d.Cooldown = Cooldown(policy, d.Scale)
+
+        if e.Breaches != nil {
+            e.Breaches[i] = e.Breaches[i].Next(d.Scale, e.Now)
+            if ok && e.Breaches[i].Lasted(policy, e.Now) == false {
+                e.log("Breaching", d.Scale, "for", e.Breaches[i].Evaluations, "of", policy.Breach_evaluations, "evaluations, since", e.Now - e.Breaches[i].Since, "of", policy.Breach_duration, "seconds")
+                continue
+            }
+        }
+        if ok {
+            decisions = append(decisions, d)
+        }
+    }
+    if len(decisions) == 0 {
+        return Decision{}, false
+    }
+    scale := ScaleIn // Any scale out wins, see Resolve
+    for _, d := range decisions {
+        if d.Scale == ScaleOut {
+            scale = ScaleOut
+        }
+    }
+    if reason, blocked := e.blocked(scale); blocked {
+        e.log("Scaling", scale, reason)
+        return Decision{}, false
+    }
+
+    num := 0
+    if len(decisions) > 1 { // Compare the decisions on the current instances
+        var err error
+        num, err = e.Instances()
+        if err != nil {
+            e.log("Error occurs when getting number of instances:", err)
+            return Decision{}, false
+        }
+    }
+    d, ok := Resolve(decisions, len(e.Policies), num)
+    if ok == false {
+        e.log("No scaling,", len(decisions), "of", len(e.Policies), "policies scale in")
+        return Decision{}, false
+    }
+    e.log("Scale", d.Scale, "by policy", d.Policy, ":", d.Resolution)
+    if limit, ok := e.Limits[d.Scale]; ok {
+        d.Limit = limit
+    }
+
+    if act(d) == false {
+        return d, false
+    }
+    for i := range e.Breaches { // Start over on the new instances
+        e.Breaches[i] = Breach{}
+    }
+    return d, true
+}
+
+// blocked tells whether the app can't be scaled in the direction scale
+// because it cools down or is held, and why.
+func (e Evaluator) blocked(scale string) (string, bool) {
+    if e.Now < e.Cooldowns[scale] {
+        return fmt.Sprint("cools down for ", e.Cooldowns[scale] - e.Now, " seconds"), true
+    }
+    if reason, held := e.Holds[scale]; held {
+        return "held: " + reason, true
+    }
+    return "", false
+}
+
+// once returns instances remembering its first result, nil if instances is nil.
+func once(instances func() (int, error)) func() (int, error) {
+    if instances == nil {
+        return nil
+    }
+    done := false
+    var num int
+    var err error
+    return func() (int, error) {
+        if done == false {
+            num, err = instances()
+            done = true
+        }
+        return num, err
+    }
+}
+
+// Cooldown returns the seconds scaling in the direction scale cools down after the policy scaled so.
+func Cooldown(policy Policy, scale string) int {
+    if scale == ScaleOut && policy.Cooldown_out > 0 {
+        return policy.Cooldown_out
+    }
+    if scale == ScaleIn && policy.Cooldown_in > 0 {
+        return policy.Cooldown_in
+    }
+    return policy.Cooldown_period
+}
+
+// Resolve picks the decision of the app among the decisions of its policies,
+// num being its current number of instances, see Run. The Resolution of the
+// picked decision tells why it won.
+func Resolve(decisions []Decision, policies int, num int) (Decision, bool) {
+    var outs, ins []Decision
+    for _, d := range decisions {
+        if d.Scale == ScaleOut {
+            outs = append(outs, d)
+        } else {
+            ins = append(ins, d)
+        }
+    }
+
+    if len(outs) > 0 {
+        best := outs[0]
+        for _, d := range outs[1:] {
+            if Delta(d, num) > Delta(best, num) {
+                best = d
+            }
+        }
+        best.Resolution = fmt.Sprintf("largest of %d scale out, %d scale in overruled", len(outs), len(ins))
+        return best, true
+    }
+
+    if len(ins) < policies {
+        return Decision{}, false // Some policies don't agree
+    }
+    best := ins[0]
+    for _, d := range ins[1:] {
+        if Delta(d, num) < Delta(best, num) {
+            best = d
+        }
+    }
+    best.Resolution = fmt.Sprintf("smallest of %d scale in, all policies agree", len(ins))
+    return best, true
+}
+
+// evaluate decides for a threshold, target or step policy.
+func (e Evaluator) evaluate(i int, policy Policy) (Decision, bool) {
+    metric, ok := PolicyMetric(policy)
+    if ok == false {
+        e.log("Unknown metric of policy:", policy.Metric_name, policy.Metric_type)
+        return Decision{}, false // Skip this policy
+    }
+
+    m, agg, samples, err := e.Observe(policy, metric)
+    if err != nil {
+        e.log("Error occurs when getting avg metric:", err)
+        return Decision{}, false // Skip this policy
+    }
+    if samples == 0 {
+        e.log("No data of metric", metric.Name)
+        return Decision{}, false // Skip this policy
+    }
+    if metric.InRange(m) == false {
+        e.log("Value of", metric.Name, "out of range:", m)
+        return Decision{}, false // Skip this policy
+    }
+
+    // Scale ahead of the forecast, and not in before it falls, without it as observed
+    forecast := 0.0
+    if policy.Predictive && e.Forecast != nil {
+        lead := ForecastLead(policy)
+        f, ok, err := e.Forecast(policy, metric, e.Now + lead)
+        if err != nil {
+            e.log("Error occurs when getting forecast:", err)
+        } else if ok == false {
+            e.log("No forecast of metric", metric.Name)
+        } else if f > m {
+            e.log("Forecast of", metric.Name, "in", lead, "seconds:", f, "above", m)
+            m, forecast = f, f
+        }
+    }
+
+    m_type := metric.Name
+    num := 0
+    if policy.Per_instance || policy.Policy_type == PolicyTarget {
+        num, err = e.Instances()
+        if err != nil {
+            e.log("Error occurs when getting number of instances:", err)
+            return Decision{}, false // Skip this policy
+        }
+    }
+    if policy.Per_instance {
+        if num > 0 {
+            m = m / float64(num)
+        }
+        m_type = m_type + " per instance"
+    }
+
+    d := Decision{Policy: i, Metric: m_type, Aggregation: agg, Value: m, Forecast: forecast}
+    if policy.Policy_type == PolicyTarget {
+        e.log(m_type, agg, "=", m, ", T =", policy.Target_value, ", instances =", num)
+
+        desired, ok := Desired(policy, m, num)
+        if ok == false {
+            return Decision{}, false
+        }
+        d.Threshold, d.Desired = policy.Target_value, desired
+        if desired > num {
+            d.Scale, d.Instances = ScaleOut, desired - num
+        } else {
+            d.Scale, d.Instances = ScaleIn, num - desired
+        }
+    } else if policy.Policy_type == PolicyStep {
+        e.log(m_type, agg, "=", m, ",", len(policy.Steps), "steps")
+
+        step, ok := MatchStep(policy.Steps, m)
+        if ok == false {
+            return Decision{}, false
+        }
+        d.Scale, d.Threshold, d.Instances = step.Scale, step.Threshold, step.Instances
+    } else {
+        e.log(m_type, agg, "=", m, ", U =", policy.Upper_threshold, ", L =", policy.Lower_threshold)
+
+        if m > policy.Upper_threshold {
+            d.Scale, d.Threshold, d.Instances = ScaleOut, policy.Upper_threshold, policy.Instances_out
+        } else if m < policy.Lower_threshold {
+            d.Scale, d.Threshold, d.Instances = ScaleIn, policy.Lower_threshold, policy.Instances_in
+        } else {
+            return Decision{}, false
+        }
+    }
+    if policy.Policy_type != PolicyTarget {
+        d = adjust(policy, d)
+    }
+    return d, true
+}
+
+// condition decides for a condition policy: out when Out_condition holds,
+// or else in when In_condition holds. Every comparison of both conditions is
+// observed first, so that the policy is skipped when any metric lacks data.
+func (e Evaluator) condition(i int, policy Policy) (Decision, bool) {
+    conds, err := PolicyConditions(policy)
+    if err != nil {
+        e.log("Invalid condition of policy:", err)
+        return Decision{}, false
+    }
+
+    num := 0
+    if policy.Per_instance {
+        num, err = e.Instances()
+        if err != nil {
+            e.log("Error occurs when getting number of instances:", err)
+            return Decision{}, false
+        }
+    }
+
+    values := make(map[string]float64)
+    for _, c := range conds {
+        for _, cmp := range c.Comparisons() {
+            if _, done := values[cmp.Key()]; done {
+                continue
+            }
+            metric, ok := registry.Lookup(cmp.Metric)
+            if ok == false {
+                e.log("Unknown metric of condition:", cmp.Metric)
+                return Decision{}, false
+            }
+            if cmp.Aggregation != "" {
+                metric.Aggregation = cmp.Aggregation
+            }
+
+            m, _, samples, err := e.Observe(policy, metric)
+            if err != nil {
+                e.log("Error occurs when getting avg metric:", err)
+                return Decision{}, false
+            }
+            if samples == 0 {
+                e.log("No data of metric", metric.Name)
+                return Decision{}, false
+            }
+            if metric.InRange(m) == false {
+                e.log("Value of", metric.Name, "out of range:", m)
+                return Decision{}, false
+            }
+            if policy.Per_instance && num > 0 {
+                m = m / float64(num)
+            }
+            values[cmp.Key()] = m
+        }
+    }
+
+    for _, scale := range []string{ScaleOut, ScaleIn} {
+        c, exist := conds[scale]
+        if exist == false {
+            continue
+        }
+        holds, _ := c.Eval(values)
+        e.log("Scale", scale, "when", c.String(), ":", holds, values)
+        if holds == false {
+            continue
+        }
+
+        d := Decision{Policy: i, Scale: scale, Metric: c.String(), Values: values, Instances: policy.Instances_out}
+        if scale == ScaleIn {
+            d.Instances = policy.Instances_in
+        }
+        if policy.Per_instance {
+            d.Metric = d.Metric + " per instance"
+        }
+        return adjust(policy, d), true
+    }
+    return Decision{}, false
+}
+
+// PolicyConditions parses the conditions of a condition policy, by direction,
+// leaving out empty ones.
+func PolicyConditions(policy Policy) (map[string]Condition, error) {
+    conds := make(map[string]Condition)
+    for scale, s := range map[string]string{ScaleOut: policy.Out_condition, ScaleIn: policy.In_condition} {
+        if s == "" {
+            continue
+        }
+        c, err := ParseCondition(s)
+        if err != nil {
+            return nil, err
+        }
+        conds[scale] = c
+    }
+    return conds, nil
+}
+
+// PolicyMetrics returns the registered metrics a policy observes, each with
+// the aggregation it is observed with.
+func PolicyMetrics(policy Policy) ([]registry.Metric, error) {
+    if policy.Policy_type != PolicyCondition {
+        metric, ok := PolicyMetric(policy)
+        if ok == false {
+            return nil, errors.New("Unknown metric of policy: " + policy.Metric_name)
+        }
+        return []registry.Metric{metric}, nil
+    }
+
+    conds, err := PolicyConditions(policy)
+    if err != nil {
+        return nil, err
+    }
+    var metrics []registry.Metric
+    for _, c := range conds {
+        for _, cmp := range c.Comparisons() {
+            metric, ok := registry.Lookup(cmp.Metric)
+            if ok == false {
+                return nil, errors.New("Unknown metric of condition: " + cmp.Metric)
+            }
+            if cmp.Aggregation != "" {
+                metric.Aggregation = cmp.Aggregation
+            }
+            metrics = append(metrics, metric)
+        }
+    }
+    return metrics, nil
+}
+
+// adjust turns the instances of a decision into a percentage for percent adjustments.
+func adjust(policy Policy, d Decision) Decision {
+    if policy.Adjustment_type == AdjustPercent {
+        d.Percent, d.Instances = d.Instances, policy.Min_adjustment
+        if d.Instances < 1 {
+            d.Instances = 1
+        }
+    }
+    return d
+}
+
+// Desired returns the number of instances which would bring the metric of a
+// target policy to its target value, assuming the metric is proportional to
+// the load per instance: num * value / target. ok is false when the value is
+// within the tolerance, or the desired number is the current one.
+func Desired(policy Policy, value float64, num int) (desired int, ok bool) {
+    if num <= 0 || policy.Target_value <= 0 {
+        return num, false // Nothing to be proportional to
+    }
+    if math.Abs(value - policy.Target_value) <= policy.Tolerance * policy.Target_value {
+        return num, false
+    }
+
+    desired = int(math.Ceil(float64(num) * value / policy.Target_value))
+    if desired < 1 {
+        desired = 1
+    }
+    return desired, desired != num
+}
+
+// MatchStep returns the band value falls in: the ScaleOut step of the highest
+// threshold below value, or else the ScaleIn step of the lowest threshold above it.
+func MatchStep(steps []Step, value float64) (Step, bool) {
+    var match Step
+    var ok bool
+    for _, s := range steps {
+        if s.Scale == ScaleOut && value > s.Threshold && (ok == false || s.Threshold > match.Threshold) {
+            match, ok = s, true
+        }
+    }
+    if ok {
+        return match, true
+    }
+
+    for _, s := range steps {
+        if s.Scale == ScaleIn && value < s.Threshold && (ok == false || s.Threshold < match.Threshold) {
+            match, ok = s, true
+        }
+    }
+    return match, ok
+}
+
+// ValidateSteps checks the bands of a step policy: at least one, each adding
+// or removing instances, distinct thresholds per direction, and every ScaleIn
+// threshold not above the ScaleOut ones so that bands don't overlap.
+func ValidateSteps(steps []Step) error {
+    if len(steps) == 0 {
+        return errors.New("Steps are missing")
+    }
+
+    seen := map[string]map[float64]bool{ScaleOut: {}, ScaleIn: {}}
+    for _, s := range steps {
+        if s.Scale != ScaleOut && s.Scale != ScaleIn {
+            return errors.New("Scale of a step must be out or in")
+        }
+        if s.Instances <= 0 {
+            return errors.New("Instances of a step must be positive")
+        }
+        if seen[s.Scale][s.Threshold] {
+            return errors.New("Thresholds of steps must be distinct")
+        }
+        seen[s.Scale][s.Threshold] = true
+    }
+
+    for _, in := range steps {
+        for _, out := range steps {
+            if in.Scale == ScaleIn && out.Scale == ScaleOut && in.Threshold > out.Threshold {
+                return errors.New("Scale in steps overlap scale out steps")
+            }
+        }
+    }
+    return nil
+}
+
+func (e Evaluator) log(v ...interface{}) {
+    if e.Log != nil {
+        e.Log(v...)
+    }
+}
+
+// Target returns the number of instances after scaling num by the decision,
+// within [min, max] and by no more than its Limit. Like the Cloud Controller client, it refuses to scale
+// an app which is already at, or beyond, the limit.
+func Target(d Decision, num int, min int, max int) (int, error) {
+    if d.Desired > 0 { // Target policy, in one action
+        desired := d.Desired
+        if desired > max {
+            desired = max
+        }
+        if desired < min {
+            desired = min
+        }
+        if d.Limit > 0 && desired > num + d.Limit {
+            desired = num + d.Limit
+        }
+        if d.Limit > 0 && desired < num - d.Limit {
+            desired = num - d.Limit
+        }
+        if d.Scale == ScaleOut && desired <= num {
+            return num, ErrMaximum
+        }
+        if d.Scale == ScaleIn && desired >= num {
+            return num, ErrMinimum
+        }
+        return desired, nil
+    }
+
+    delta := Delta(d, num)
+    if d.Limit > 0 && delta > d.Limit {
+        delta = d.Limit
+    }
+    if d.Scale == ScaleOut {
+        if num >= max { // num > max happens when users did manual scaling
+            return num, ErrMaximum
+        }
+        if num + delta > max {
+            return max, nil
+        }
+        return num + delta, nil
+    }
+
+    if num <= min {
+        return num, ErrMinimum
+    }
+    if num - delta < min {
+        return min, nil
+    }
+    return num - delta, nil
+}
+
+// Delta returns the number of instances a decision adds or removes from num
+// instances. Percentages are rounded up when scaling out and down when scaling
+// in, so that an app reacts to load at once but sheds instances cautiously,
+// and never by less than d.Instances.
+func Delta(d Decision, num int) int {
+    if d.Percent == 0 {
+        return d.Instances
+    }
+
+    delta := float64(num) * float64(d.Percent) / 100
+    if d.Scale == ScaleOut {
+        delta = math.Ceil(delta)
+    } else {
+        delta = math.Floor(delta)
+    }
+    if int(delta) < d.Instances {
+        return d.Instances
+    }
+    return int(delta)
+}
+
+// IsValidPolicyType tells whether t is a known type of policy, empty meaning PolicyThreshold.
+func IsValidPolicyType(t string) bool {
+    switch t {
+        case "", PolicyThreshold, PolicyTarget, PolicyStep, PolicyCondition:
+            return true
+    }
+    return false
+}
+
+// IsValidAdjustmentType tells whether t is a known type of adjustment, empty meaning AdjustCount.
+func IsValidAdjustmentType(t string) bool {
+    switch t {
+        case "", AdjustCount, AdjustPercent:
+            return true
+    }
+    return false
+}
@@ -0,0 +1,289 @@
+package scaling
+
+import (
+    "testing"
+
+    "registry"
+)
+
+func TestResolve(t *testing.T) {
+    tests := []struct {
+        name string
+        decisions []Decision
+        policies int
+        num int
+        ok bool
+        policy int
+    }{
+        {"scale out beats scale in", []Decision{{Policy: 0, Scale: ScaleIn, Instances: 1}, {Policy: 1, Scale: ScaleOut, Instances: 1}}, 2, 4, true, 1},
+        {"largest scale out", []Decision{{Policy: 0, Scale: ScaleOut, Instances: 1}, {Policy: 1, Scale: ScaleOut, Instances: 3}}, 2, 4, true, 1},
+        {"largest scale out by percent", []Decision{{Policy: 0, Scale: ScaleOut, Instances: 2}, {Policy: 1, Scale: ScaleOut, Instances: 1, Percent: 50}}, 2, 10, true, 1},
+        {"tie goes to the first policy", []Decision{{Policy: 0, Scale: ScaleOut, Instances: 2}, {Policy: 1, Scale: ScaleOut, Instances: 2}}, 2, 4, true, 0},
+        {"scale in when all policies agree", []Decision{{Policy: 0, Scale: ScaleIn, Instances: 2}, {Policy: 1, Scale: ScaleIn, Instances: 1}}, 2, 4, true, 1},
+        {"no scale in when a policy disagrees", []Decision{{Policy: 0, Scale: ScaleIn, Instances: 1}}, 2, 4, false, 0},
+    }
+    for _, tt := range tests {
+        d, ok := Resolve(tt.decisions, tt.policies, tt.num)
+        if ok != tt.ok {
+            t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+            continue
+        }
+        if ok && d.Policy != tt.policy {
+            t.Errorf("%s: policy = %d, want %d", tt.name, d.Policy, tt.policy)
+        }
+        if ok && d.Resolution == "" {
+            t.Errorf("%s: resolution is missing", tt.name)
+        }
+    }
+}
+
+func TestDelta(t *testing.T) {
+    tests := []struct {
+        name string
+        d Decision
+        num int
+        want int
+    }{
+        {"count", Decision{Scale: ScaleOut, Instances: 2}, 10, 2},
+        {"percent out rounds up", Decision{Scale: ScaleOut, Instances: 1, Percent: 15}, 10, 2},
+        {"percent in rounds down", Decision{Scale: ScaleIn, Instances: 1, Percent: 15}, 10, 1},
+        {"percent in below min adjustment", Decision{Scale: ScaleIn, Instances: 2, Percent: 10}, 5, 2},
+        {"percent out below min adjustment", Decision{Scale: ScaleOut, Instances: 3, Percent: 10}, 10, 3},
+        {"percent of no instances", Decision{Scale: ScaleIn, Instances: 1, Percent: 50}, 0, 1},
+    }
+    for _, tt := range tests {
+        if got := Delta(tt.d, tt.num); got != tt.want {
+            t.Errorf("%s: Delta = %d, want %d", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestAdjust(t *testing.T) {
+    tests := []struct {
+        name string
+        policy Policy
+        instances int
+        percent int
+        want int
+    }{
+        {"count", Policy{Adjustment_type: AdjustCount, Min_adjustment: 3}, 2, 0, 2},
+        {"percent, min adjustment 1 if 0", Policy{Adjustment_type: AdjustPercent}, 20, 20, 1},
+        {"percent with min adjustment", Policy{Adjustment_type: AdjustPercent, Min_adjustment: 3}, 20, 20, 3},
+    }
+    for _, tt := range tests {
+        d := adjust(tt.policy, Decision{Scale: ScaleOut, Instances: tt.instances})
+        if d.Percent != tt.percent || d.Instances != tt.want {
+            t.Errorf("%s: Percent, Instances = %d, %d, want %d, %d", tt.name, d.Percent, d.Instances, tt.percent, tt.want)
+        }
+    }
+}
+
+func TestTarget(t *testing.T) {
+    tests := []struct {
+        name string
+        d Decision
+        num int
+        min int
+        max int
+        want int
+        err error
+    }{
+        {"out", Decision{Scale: ScaleOut, Instances: 2}, 3, 1, 10, 5, nil},
+        {"out clamped to max", Decision{Scale: ScaleOut, Instances: 5}, 8, 1, 10, 10, nil},
+        {"out at max", Decision{Scale: ScaleOut, Instances: 1}, 10, 1, 10, 10, ErrMaximum},
+        {"out beyond max", Decision{Scale: ScaleOut, Instances: 1}, 12, 1, 10, 12, ErrMaximum},
+        {"in", Decision{Scale: ScaleIn, Instances: 2}, 5, 1, 10, 3, nil},
+        {"in clamped to min", Decision{Scale: ScaleIn, Instances: 5}, 4, 2, 10, 2, nil},
+        {"in at min", Decision{Scale: ScaleIn, Instances: 1}, 2, 2, 10, 2, ErrMinimum},
+        {"out by percent", Decision{Scale: ScaleOut, Instances: 1, Percent: 50}, 5, 1, 10, 8, nil},
+        {"in by percent", Decision{Scale: ScaleIn, Instances: 1, Percent: 50}, 5, 1, 10, 3, nil},
+        {"out limited", Decision{Scale: ScaleOut, Instances: 4, Limit: 2}, 3, 1, 10, 5, nil},
+        {"in limited", Decision{Scale: ScaleIn, Instances: 4, Limit: 1}, 6, 1, 10, 5, nil},
+        {"desired", Decision{Scale: ScaleOut, Desired: 7}, 3, 1, 10, 7, nil},
+        {"desired clamped to max", Decision{Scale: ScaleOut, Desired: 15}, 3, 1, 10, 10, nil},
+        {"desired clamped to min", Decision{Scale: ScaleIn, Desired: 1}, 5, 2, 10, 2, nil},
+        {"desired limited", Decision{Scale: ScaleIn, Desired: 2, Limit: 1}, 5, 1, 10, 4, nil},
+        {"desired at max", Decision{Scale: ScaleOut, Desired: 12}, 10, 1, 10, 10, ErrMaximum},
+        {"desired at min", Decision{Scale: ScaleIn, Desired: 1}, 2, 2, 10, 2, ErrMinimum},
+    }
+    for _, tt := range tests {
+        got, err := Target(tt.d, tt.num, tt.min, tt.max)
+        if got != tt.want || err != tt.err {
+            t.Errorf("%s: Target = %d, %v, want %d, %v", tt.name, got, err, tt.want, tt.err)
+        }
+    }
+}
+
+func TestDesired(t *testing.T) {
+    tests := []struct {
+        name string
+        policy Policy
+        value float64
+        num int
+        want int
+        ok bool
+    }{
+        {"above target", Policy{Target_value: 50}, 75, 4, 6, true},
+        {"below target", Policy{Target_value: 50}, 25, 4, 2, true},
+        {"rounds up", Policy{Target_value: 50}, 60, 4, 5, true},
+        {"at least one", Policy{Target_value: 50}, 0, 4, 1, true},
+        {"within tolerance", Policy{Target_value: 50, Tolerance: 0.1}, 54, 4, 4, false},
+        {"beyond tolerance", Policy{Target_value: 50, Tolerance: 0.1}, 60, 4, 5, true},
+        {"slightly above target", Policy{Target_value: 50}, 51, 10, 11, true},
+        {"rounds to the current number", Policy{Target_value: 50}, 45, 4, 4, false},
+        {"no instances", Policy{Target_value: 50}, 75, 0, 0, false},
+        {"no target", Policy{}, 75, 4, 4, false},
+    }
+    for _, tt := range tests {
+        got, ok := Desired(tt.policy, tt.value, tt.num)
+        if got != tt.want || ok != tt.ok {
+            t.Errorf("%s: Desired = %d, %v, want %d, %v", tt.name, got, ok, tt.want, tt.ok)
+        }
+    }
+}
+
+func TestMatchStep(t *testing.T) {
+    steps := []Step{
+        {Scale: ScaleOut, Threshold: 85, Instances: 3},
+        {Scale: ScaleOut, Threshold: 70, Instances: 1},
+        {Scale: ScaleOut, Threshold: 95, Instances: 5},
+        {Scale: ScaleIn, Threshold: 30, Instances: 1},
+        {Scale: ScaleIn, Threshold: 10, Instances: 2},
+    }
+    tests := []struct {
+        value float64
+        ok bool
+        scale string
+        instances int
+    }{
+        {99, true, ScaleOut, 5},
+        {95, true, ScaleOut, 3},
+        {90, true, ScaleOut, 3},
+        {71, true, ScaleOut, 1},
+        {70, false, "", 0},
+        {50, false, "", 0},
+        {30, false, "", 0},
+        {20, true, ScaleIn, 1},
+        {5, true, ScaleIn, 2},
+    }
+    for _, tt := range tests {
+        s, ok := MatchStep(steps, tt.value)
+        if ok != tt.ok || ok && (s.Scale != tt.scale || s.Instances != tt.instances) {
+            t.Errorf("MatchStep(%v) = %+v, %v, want %s %d, %v", tt.value, s, ok, tt.scale, tt.instances, tt.ok)
+        }
+    }
+}
+
+func TestValidateSteps(t *testing.T) {
+    tests := []struct {
+        name string
+        steps []Step
+        ok bool
+    }{
+        {"valid", []Step{{ScaleOut, 70, 1}, {ScaleOut, 90, 3}, {ScaleIn, 30, 1}}, true},
+        {"in at the out threshold", []Step{{ScaleOut, 50, 1}, {ScaleIn, 50, 1}}, true},
+        {"missing", nil, false},
+        {"unknown scale", []Step{{"up", 70, 1}}, false},
+        {"no instances", []Step{{ScaleOut, 70, 0}}, false},
+        {"duplicate threshold", []Step{{ScaleOut, 70, 1}, {ScaleOut, 70, 2}}, false},
+        {"overlap", []Step{{ScaleOut, 50, 1}, {ScaleIn, 60, 1}}, false},
+    }
+    for _, tt := range tests {
+        err := ValidateSteps(tt.steps)
+        if (err == nil) != tt.ok {
+            t.Errorf("%s: ValidateSteps = %v, want ok %v", tt.name, err, tt.ok)
+        }
+    }
+}
+
+func TestBreach(t *testing.T) {
+    policy := Policy{Breach_evaluations: 3, Breach_duration: 120}
+    tests := []struct {
+        name string
+        scales []string // one per minute
+        evaluations int
+        since int
+        lasted bool
+    }{
+        {"first breach", []string{ScaleOut}, 1, 0, false},
+        {"not long enough", []string{ScaleOut, ScaleOut}, 2, 0, false},
+        {"lasted", []string{ScaleOut, ScaleOut, ScaleOut}, 3, 0, true},
+        {"reset by no decision", []string{ScaleOut, ScaleOut, "", ScaleOut}, 1, 180, false},
+        {"reset by the other direction", []string{ScaleOut, ScaleOut, ScaleIn}, 1, 120, false},
+        {"lasted after reset", []string{ScaleIn, ScaleOut, ScaleOut, ScaleOut, ScaleOut}, 4, 60, true},
+    }
+    for _, tt := range tests {
+        var b Breach
+        now := 0
+        for i, scale := range tt.scales {
+            now = i * 60
+            b = b.Next(scale, now)
+        }
+        if b.Evaluations != tt.evaluations || b.Since != tt.since {
+            t.Errorf("%s: breach = %+v, want %d evaluations since %d", tt.name, b, tt.evaluations, tt.since)
+        }
+        if lasted := b.Lasted(policy, now); lasted != tt.lasted {
+            t.Errorf("%s: Lasted = %v, want %v", tt.name, lasted, tt.lasted)
+        }
+    }
+}
+
+func TestRunInstances(t *testing.T) {
+    target := Policy{Policy_type: PolicyTarget, Metric_name: "cpu", Target_value: 50}
+    threshold := Policy{Policy_type: PolicyThreshold, Metric_name: "cpu", Upper_threshold: 70, Lower_threshold: 30, Instances_out: 1, Instances_in: 1}
+    tests := []struct {
+        name string
+        policies []Policy
+        cooldowns map[string]int
+        holds map[string]string
+        calls int // of Instances, at most
+        acted bool
+    }{
+        {"target", []Policy{target}, nil, nil, 1, true},
+        {"asked once by several policies", []Policy{target, target, threshold}, nil, nil, 1, true},
+        {"both directions cool down", []Policy{target}, map[string]int{ScaleOut: 200, ScaleIn: 200}, nil, 0, false},
+        {"both directions held or cool down", []Policy{target}, map[string]int{ScaleOut: 200}, map[string]string{ScaleIn: "window"}, 0, false},
+        {"scale out cools down", []Policy{threshold, threshold}, map[string]int{ScaleOut: 200}, nil, 0, false},
+    }
+    for _, tt := range tests {
+        calls := 0
+        e := Evaluator{
+            Policies: tt.policies,
+            Observe: func(p Policy, m registry.Metric) (float64, string, int, error) {
+                return 90, m.Aggregation, 1, nil
+            },
+            Instances: func() (int, error) {
+                calls++
+                return 4, nil
+            },
+            Now: 100,
+            Cooldowns: tt.cooldowns,
+            Holds: tt.holds}
+        _, acted := e.Run(func(d Decision) bool { return true })
+        if calls > tt.calls || acted != tt.acted {
+            t.Errorf("%s: %d calls of Instances, acted %v, want at most %d, %v", tt.name, calls, acted, tt.calls, tt.acted)
+        }
+    }
+}
+
+func TestRunOutOfRange(t *testing.T) {
+    policy := Policy{Policy_type: PolicyThreshold, Metric_name: "cpu", Upper_threshold: 70, Lower_threshold: 30, Instances_out: 1, Instances_in: 1}
+    tests := []struct {
+        value float64
+        acted bool
+    }{
+        {90, true},
+        {-1, false},
+        {7000, false},
+    }
+    for _, tt := range tests {
+        e := Evaluator{
+            Policies: []Policy{policy},
+            Observe: func(p Policy, m registry.Metric) (float64, string, int, error) {
+                return tt.value, m.Aggregation, 1, nil
+            }}
+        if _, acted := e.Run(func(d Decision) bool { return true }); acted != tt.acted {
+            t.Errorf("cpu of %v: acted %v, want %v", tt.value, acted, tt.acted)
+        }
+    }
+}
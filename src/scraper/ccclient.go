@@ -0,0 +1,112 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "strings"
+)
+
+type CCClient struct {
+    api_host string
+    auth_host string
+    auth_user string
+    auth_pass string
+}
+
+type Token struct {
+    Access_token string
+    Token_type string
+    Refresh_token string
+    Expires_in int
+    Scope string
+    Jti string
+}
+
+type App struct {
+    Name string
+    Instances int
+}
+
+// GetNumInstances returns the desired number of instances of the app.
+func (c *CCClient) GetNumInstances(app_uuid string) (num int, err error) {
+    API_URI := strings.Join([]string{"http://", c.api_host, "/v2/apps/", app_uuid, "/summary"}, "")
+
+    req, err := http.NewRequest("GET", API_URI, nil)
+    if err != nil {
+        log.Println("Cannot create GET request to the API host.")
+        return 0, err
+    }
+    token, err := c.getToken()
+    if err != nil {
+        log.Println("Cannot get token.")
+        return 0, err
+    }
+    req.Header.Add("Authorization", "Bearer " + token)
+    req.Header.Add("Accept", "application/json")
+
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        log.Println("Request to the API host failed.")
+        return 0, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return 0, errors.New("API host responded " + resp.Status)
+    }
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        log.Println("Cannot read the response from API server.")
+        return 0, err
+    }
+
+    var app App
+    err = json.Unmarshal(body, &app)
+    if err != nil {
+        log.Println("Cannot decode JSON message from API server.")
+        return 0, err
+    }
+
+    return app.Instances, nil
+}
+
+func (c *CCClient) getToken() (token string, err error) {
+    // TODO: Use refresh_token
+    auth_URI := strings.Join([]string{"http://", c.auth_host, "/oauth/token"}, "")
+    data := strings.Join([]string{"grant_type=password&username=", c.auth_user, "&password=", c.auth_pass}, "")
+    req, err := http.NewRequest("POST", auth_URI, strings.NewReader(data))
+    if err != nil {
+        log.Println("Cannot create POST request to the authentication server.")
+        return "", err
+    }
+
+    req.Header.Add("Authorization", "Basic Y2Y6")
+    req.Header.Add("Accept", "application/json, application/x-www-form-urlencoded")
+    req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+    client := &http.Client{}
+    resp, err := client.Do(req)
+    if err != nil {
+        log.Println("Request to the authentication failed.")
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        log.Println("Cannot read response from the authentication server.")
+        return "", err
+    }
+    var t Token
+    err = json.Unmarshal(body, &t)
+    if err != nil {
+        log.Println("Decode JSON failed.")
+        return "", err
+    }
+    return t.Access_token, nil
+}
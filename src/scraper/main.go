@@ -0,0 +1,317 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    _ "github.com/go-sql-driver/mysql"
+)
+
+var pdb *sql.DB
+var mdb *sql.DB
+var store MetricStore
+var ccc CCClient
+var cfg Configuration
+var duration int = 10 // seconds
+var avgerConn net.Conn
+var avgerLock sync.Mutex
+var httpClient *http.Client
+
+type Configuration struct {
+    PolicyDB map[string]string
+    MetricDB map[string]string
+    CloudController map[string]string
+    AvgerHost string
+    AvgerPort string
+    Duration int // seconds between reloads of the scrape and queue configs
+    Timeout int // seconds, for each scrape
+    Backend string // where metrics are kept: "mysql" (default, MetricDB) or "influxdb", as for the monitor and the api
+    InfluxDB map[string]string // Url, Database, Username, Password
+    Log string
+}
+
+// ScrapeConfig tells where to scrape the metrics of an app and which series to keep.
+type ScrapeConfig struct {
+    App_uuid string
+    Scrape_uuid string
+    Url string
+    Scrape_interval int // seconds
+    Per_instance bool // scrape every instance through the X-CF-APP-INSTANCE header
+    Mappings []Mapping
+}
+
+// Mapping selects the series with the given name and labels and stores their sum as metric Name.
+type Mapping struct {
+    Series string
+    Labels map[string]string
+    Name string
+}
+
+func LoadScrapeConfigs() ([]ScrapeConfig, error) {
+    configs := []ScrapeConfig{}
+    rows, err := pdb.Query("SELECT s.app_uuid, s.scrape_uuid, s.url, s.scrape_interval, s.per_instance, s.mappings FROM scrapes s JOIN apps a ON a.app_uuid = s.app_uuid WHERE s.deleted = false AND a.enabled = ?", 1)
+    if err != nil {
+        log.Println("Error occurs when selecting scrape configs:", err)
+        return configs, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var c ScrapeConfig
+        var mappings string
+        if err := rows.Scan(&c.App_uuid, &c.Scrape_uuid, &c.Url, &c.Scrape_interval, &c.Per_instance, &mappings); err != nil {
+            log.Println("Error occurs when scanning rows:", err)
+            continue // skip this config
+        }
+        if err := json.Unmarshal([]byte(mappings), &c.Mappings); err != nil {
+            log.Println("Error occurs when decoding mappings of", c.Scrape_uuid, err)
+            continue
+        }
+        configs = append(configs, c)
+    }
+    if err := rows.Err(); err != nil {
+        log.Println("Error occurs when scanning rows:", err)
+        return configs, err
+    }
+
+    return configs, nil
+}
+
+func Scrape(c ScrapeConfig) {
+    if c.Per_instance == false {
+        ScrapeInstance(c, "", "")
+        return
+    }
+
+    num, err := ccc.GetNumInstances(c.App_uuid)
+    if err != nil {
+        log.Println("Error occurs when getting number of instances of", c.App_uuid, err)
+        return
+    }
+    for i := 0; i < num; i++ {
+        index := strconv.Itoa(i)
+        ScrapeInstance(c, index, c.App_uuid + ":" + index)
+    }
+}
+
+// ScrapeInstance scrapes c.Url once, routed to an instance when cf_instance is not empty,
+// and pushes the mapped metrics under instance_uuid.
+func ScrapeInstance(c ScrapeConfig, instance_uuid string, cf_instance string) {
+    series, err := Fetch(c.Url, cf_instance)
+    if err != nil {
+        log.Println("Error occurs when scraping", c.Url, cf_instance, err)
+        return
+    }
+
+    values := make(map[string]float64)
+    for _, m := range c.Mappings {
+        var matched bool
+        var sum float64
+        for _, s := range series {
+            if s.Matches(m.Series, m.Labels) {
+                matched = true
+                sum = sum + s.Value
+            }
+        }
+        if matched {
+            values[m.Name] = sum
+        }
+    }
+
+    if len(values) == 0 {
+        return
+    }
+    Push(c.App_uuid, instance_uuid, values)
+}
+
+func Fetch(url string, cf_instance string) ([]Series, error) {
+    req, err := http.NewRequest("GET", url, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Add("Accept", "text/plain; version=0.0.4")
+    if cf_instance != "" {
+        req.Header.Add("X-CF-APP-INSTANCE", cf_instance)
+    }
+
+    resp, err := httpClient.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return nil, errors.New("Scrape target responded " + resp.Status)
+    }
+
+    return ParseExposition(resp.Body)
+}
+
+// Push stores the metrics to the metric store and forwards them to the avger, like the monitor does.
+func Push(app_uuid string, instance_uuid string, values map[string]float64) {
+    now := int32(time.Now().Unix())
+    var pairs []string
+    for name, value := range values {
+        err := store.AddNamed(app_uuid, instance_uuid, name, value, now)
+        if err != nil {
+            log.Println("Cannot save to the metric store:", err)
+        }
+        pairs = append(pairs, name + "=" + strconv.FormatFloat(value, 'f', -1, 64))
+    }
+    log.Println("Saved: ", app_uuid, instance_uuid, pairs)
+
+    if instance_uuid == "" {
+        instance_uuid = "-"
+    }
+    message := app_uuid + " " + instance_uuid + " " + strings.Join(pairs, " ") + "\n"
+    if err := SendToAvger([]byte(message)); err != nil {
+        log.Println("Cannot send to the avger:", err)
+    }
+}
+
+// SendToAvger writes to the avger, re-connecting after a failure.
+func SendToAvger(msg []byte) error {
+    avgerLock.Lock()
+    defer avgerLock.Unlock()
+
+    if avgerConn == nil {
+        conn, err := net.Dial("tcp", cfg.AvgerHost + ":" + cfg.AvgerPort)
+        if err != nil {
+            return err
+        }
+        avgerConn = conn
+    }
+
+    _, err := avgerConn.Write(msg)
+    if err != nil {
+        avgerConn.Close()
+        avgerConn = nil
+        return err
+    }
+    return nil
+}
+
+func init() {
+    cfgPtr := flag.String("config", "config/scraper.json", "Path to the config file")
+    flag.Parse()
+
+    f, err := os.Open(*cfgPtr)
+    if err != nil {
+        fmt.Println("Cannot open the config file:", err)
+        os.Exit(1)
+    }
+
+    err = json.NewDecoder(f).Decode(&cfg)
+    if err != nil {
+        fmt.Println("Cannot decode the config file:", err)
+        os.Exit(1)
+    }
+
+    pdb_dsn := cfg.PolicyDB["Username"]+":"+cfg.PolicyDB["Password"]+"@tcp("+cfg.PolicyDB["Host"]+":"+cfg.PolicyDB["Port"]+")/"+cfg.PolicyDB["Database"]
+    pdb, err = sql.Open("mysql", pdb_dsn)
+    if err != nil {
+        fmt.Println("Cannot connect to the Policy database:", err)
+        os.Exit(1)
+    }
+
+    mdb_dsn := cfg.MetricDB["Username"]+":"+cfg.MetricDB["Password"]+"@tcp("+cfg.MetricDB["Host"]+":"+cfg.MetricDB["Port"]+")/"+cfg.MetricDB["Database"]
+    mdb, err = sql.Open("mysql", mdb_dsn)
+    if err != nil {
+        fmt.Println("Cannot connect to the Metric database:", err)
+        os.Exit(1)
+    }
+
+    store, err = NewMetricStore(cfg.Backend, mdb, cfg.InfluxDB)
+    if err != nil {
+        fmt.Println("Cannot set up the metric backend:", err)
+        os.Exit(1)
+    }
+
+    ccc = CCClient {
+        api_host: cfg.CloudController["Api_host"],
+        auth_host: cfg.CloudController["Auth_host"],
+        auth_user: cfg.CloudController["Auth_user"],
+        auth_pass: cfg.CloudController["Auth_pass"]}
+
+    if cfg.Duration != 0 {
+        duration = cfg.Duration
+    }
+    if cfg.Timeout == 0 {
+        cfg.Timeout = 5
+    }
+    httpClient = &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}
+
+    if cfg.Log != "" {
+        logf, err := os.OpenFile(cfg.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+        if err != nil {
+            fmt.Println("Cannot open the log file:", err)
+            os.Exit(1)
+        }
+        log.SetOutput(logf)
+    }
+}
+
+func main() {
+    defer pdb.Close()
+    defer mdb.Close()
+
+    var configs []ScrapeConfig
+    queues := make(map[string][]QueueConfig) // app_uuid -> queues
+    last_scrape := make(map[string]int) // scrape_uuid -> unix time
+    last_poll := make(map[string]int) // app_uuid -> unix time
+    var last_load int
+
+    ticker := time.NewTicker(time.Second)
+    for t := range ticker.C {
+        now := int(t.Unix())
+
+        if now - last_load >= duration {
+            c, err := LoadScrapeConfigs()
+            if err == nil {
+                configs = c
+            }
+            q, err := LoadQueueConfigs()
+            if err == nil {
+                queues = make(map[string][]QueueConfig)
+                for _, qc := range q {
+                    queues[qc.App_uuid] = append(queues[qc.App_uuid], qc)
+                }
+            }
+            last_load = now
+        }
+
+        for _, c := range configs {
+            if now - last_scrape[c.Scrape_uuid] < c.Scrape_interval {
+                continue
+            }
+            last_scrape[c.Scrape_uuid] = now
+            go Scrape(c)
+        }
+
+        // All queues of an app are polled together at the shortest interval among them
+        for app_uuid, qs := range queues {
+            interval := qs[0].Poll_interval
+            for _, qc := range qs {
+                if qc.Poll_interval < interval {
+                    interval = qc.Poll_interval
+                }
+            }
+            if now - last_poll[app_uuid] < interval {
+                continue
+            }
+            last_poll[app_uuid] = now
+            go PollQueues(app_uuid, qs)
+        }
+    }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "io"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// Series is one sample of the Prometheus text exposition format, e.g.
+// http_requests_total{method="post",code="200"} 1027 1395066363000
+type Series struct {
+    Name string
+    Labels map[string]string
+    Value float64
+}
+
+// ParseExposition parses the Prometheus text exposition format (version 0.0.4).
+// Comments, HELP and TYPE lines are skipped, so are samples whose value is NaN.
+func ParseExposition(r io.Reader) ([]Series, error) {
+    var result []Series
+
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        s, err := parseSeries(line)
+        if err != nil {
+            return nil, errors.New("Cannot parse \"" + line + "\": " + err.Error())
+        }
+        if math.IsNaN(s.Value) {
+            continue
+        }
+        result = append(result, s)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    return result, nil
+}
+
+func parseSeries(line string) (Series, error) {
+    s := Series{Labels: make(map[string]string)}
+
+    i := strings.IndexAny(line, "{ \t")
+    if i <= 0 {
+        return s, errors.New("Missing value")
+    }
+    s.Name = line[:i]
+    rest := line[i:]
+
+    if rest[0] == '{' {
+        end, err := parseLabels(rest, s.Labels)
+        if err != nil {
+            return s, err
+        }
+        rest = rest[end:]
+    }
+
+    fields := strings.Fields(rest) // value [timestamp]
+    if len(fields) == 0 || len(fields) > 2 {
+        return s, errors.New("Expecting a value and an optional timestamp")
+    }
+
+    value, err := parseValue(fields[0])
+    if err != nil {
+        return s, err
+    }
+    s.Value = value
+
+    return s, nil
+}
+
+// parseLabels reads `{name="value",...}` at the beginning of text into labels
+// and returns the position after the closing brace.
+func parseLabels(text string, labels map[string]string) (int, error) {
+    i := 1 // skip '{'
+    for {
+        for i < len(text) && (text[i] == ' ' || text[i] == ',') {
+            i++
+        }
+        if i >= len(text) {
+            return 0, errors.New("Unterminated label set")
+        }
+        if text[i] == '}' {
+            return i + 1, nil
+        }
+
+        eq := strings.IndexByte(text[i:], '=')
+        if eq < 0 {
+            return 0, errors.New("Missing '=' in label set")
+        }
+        name := strings.TrimSpace(text[i : i+eq])
+        i = i + eq + 1
+        if i >= len(text) || text[i] != '"' {
+            return 0, errors.New("Label value must be quoted")
+        }
+        i++
+
+        var value strings.Builder
+        for {
+            if i >= len(text) {
+                return 0, errors.New("Unterminated label value")
+            }
+            c := text[i]
+            if c == '"' {
+                i++
+                break
+            }
+            if c == '\\' && i+1 < len(text) {
+                i++
+                switch text[i] {
+                    case 'n':
+                        value.WriteByte('\n')
+                    default: // \\ and \"
+                        value.WriteByte(text[i])
+                }
+            } else {
+                value.WriteByte(c)
+            }
+            i++
+        }
+        labels[name] = value.String()
+    }
+}
+
+func parseValue(v string) (float64, error) {
+    switch v {
+        case "+Inf":
+            return math.Inf(1), nil
+        case "-Inf":
+            return math.Inf(-1), nil
+        case "NaN":
+            return math.NaN(), nil
+    }
+    return strconv.ParseFloat(v, 64)
+}
+
+// Matches tells whether the series has the given name and every given label.
+func (s Series) Matches(name string, labels map[string]string) bool {
+    if s.Name != name {
+        return false
+    }
+    for k, v := range labels {
+        if s.Labels[k] != v {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// QueueConfig associates a RabbitMQ queue with the app consuming it.
+type QueueConfig struct {
+    App_uuid string
+    Queue_uuid string
+    Api_url string // e.g. http://rabbitmq.example.com:15672
+    Vhost string
+    Queue string
+    Username string
+    Password string
+    Poll_interval int // seconds
+}
+
+// QueueStats is the part of GET /api/queues/{vhost}/{name} we care about
+type QueueStats struct {
+    Messages_ready float64 `json:"messages_ready"`
+    Message_stats struct {
+        Publish_details struct {
+            Rate float64 `json:"rate"`
+        } `json:"publish_details"`
+        Ack_details struct {
+            Rate float64 `json:"rate"`
+        } `json:"ack_details"`
+    } `json:"message_stats"`
+}
+
+func LoadQueueConfigs() ([]QueueConfig, error) {
+    configs := []QueueConfig{}
+    rows, err := pdb.Query("SELECT q.app_uuid, q.queue_uuid, q.api_url, q.vhost, q.queue, q.username, q.password, q.poll_interval FROM queues q JOIN apps a ON a.app_uuid = q.app_uuid WHERE q.deleted = false AND a.enabled = ?", 1)
+    if err != nil {
+        log.Println("Error occurs when selecting queue configs:", err)
+        return configs, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var c QueueConfig
+        if err := rows.Scan(&c.App_uuid, &c.Queue_uuid, &c.Api_url, &c.Vhost, &c.Queue, &c.Username, &c.Password, &c.Poll_interval); err != nil {
+            log.Println("Error occurs when scanning rows:", err)
+            continue // skip this config
+        }
+        configs = append(configs, c)
+    }
+    if err := rows.Err(); err != nil {
+        log.Println("Error occurs when scanning rows:", err)
+        return configs, err
+    }
+
+    return configs, nil
+}
+
+// PollQueues polls the queues of one app and pushes their sums as
+// queue_ready, queue_publish_rate and queue_ack_rate.
+func PollQueues(app_uuid string, queues []QueueConfig) {
+    values := make(map[string]float64)
+    var polled int
+    for _, q := range queues {
+        stats, err := FetchQueueStats(q)
+        if err != nil {
+            log.Println("Error occurs when polling queue", q.Vhost, q.Queue, err)
+            continue
+        }
+        polled++
+        values["queue_ready"] = values["queue_ready"] + stats.Messages_ready
+        values["queue_publish_rate"] = values["queue_publish_rate"] + stats.Message_stats.Publish_details.Rate
+        values["queue_ack_rate"] = values["queue_ack_rate"] + stats.Message_stats.Ack_details.Rate
+    }
+
+    // A partial sum would look like a drop of the backlog
+    if polled != len(queues) {
+        return
+    }
+    Push(app_uuid, "", values)
+}
+
+func FetchQueueStats(q QueueConfig) (QueueStats, error) {
+    var stats QueueStats
+
+    API_URI := strings.TrimRight(q.Api_url, "/") + "/api/queues/" + url.PathEscape(q.Vhost) + "/" + url.PathEscape(q.Queue)
+    req, err := http.NewRequest("GET", API_URI, nil)
+    if err != nil {
+        return stats, err
+    }
+    req.SetBasicAuth(q.Username, q.Password)
+    req.Header.Add("Accept", "application/json")
+
+    resp, err := httpClient.Do(req)
+    if err != nil {
+        return stats, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return stats, errors.New("RabbitMQ management API responded " + resp.Status)
+    }
+
+    err = json.NewDecoder(resp.Body).Decode(&stats)
+    return stats, err
+}
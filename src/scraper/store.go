@@ -0,0 +1,49 @@
+package main
+
+import (
+    "database/sql"
+
+    "influx"
+)
+
+// MetricStore is where the scraper saves the metrics it scrapes, the same
+// backend as the monitor and the api are configured with.
+type MetricStore interface {
+    AddNamed(app_uuid string, instance_uuid string, name string, value float64, created_at int32) error
+}
+
+// MySQLStore saves metrics into the named_metrics table of MetricDB.
+type MySQLStore struct {
+    db *sql.DB
+}
+
+func (s *MySQLStore) AddNamed(app_uuid string, instance_uuid string, name string, value float64, created_at int32) error {
+    _, err := s.db.Exec("INSERT INTO named_metrics (app_uuid, instance_uuid, name, value, created_at) VALUES (?, ?, ?, ?, ?);", app_uuid, instance_uuid, name, value, created_at)
+    return err
+}
+
+// InfluxStore writes metrics to an InfluxDB, in the layout of the influx
+// package, as the monitor does.
+type InfluxStore struct {
+    client *influx.Client
+}
+
+func NewInfluxStore(c map[string]string) *InfluxStore {
+    return &InfluxStore{client: influx.NewClient(c, nil)}
+}
+
+func (s *InfluxStore) AddNamed(app_uuid string, instance_uuid string, name string, value float64, created_at int32) error {
+    return s.client.Write([]byte(influx.Line("named_metrics", app_uuid, instance_uuid, name, influx.Field("value", value), int(created_at))))
+}
+
+// NewMetricStore returns the store selected by backend: "mysql" (default) or "influxdb".
+func NewMetricStore(backend string, db *sql.DB, c map[string]string) (MetricStore, error) {
+    selected, err := influx.Selected(backend, c)
+    if err != nil {
+        return nil, err
+    }
+    if selected {
+        return NewInfluxStore(c), nil
+    }
+    return &MySQLStore{db: db}, nil
+}